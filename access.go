@@ -0,0 +1,100 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// An AccessLogEntry describes a completed call, for an AccessLogger.
+type AccessLogEntry struct {
+	Ctx        context.Context
+	Method     string
+	Path       string
+	RemoteAddr string
+	RequestID  string
+	StatusCode int
+	Duration   time.Duration
+}
+
+// An AccessLogger records an entry for each call, as logged by the hooks from
+// NewAccessLogHook.
+type AccessLogger interface {
+	LogAccess(entry AccessLogEntry)
+}
+
+// stdoutAccessLogger is the default AccessLogger, which prints entries in the
+// same format as the proxies did before access logging moved to hooks.
+type stdoutAccessLogger struct{}
+
+func (stdoutAccessLogger) LogAccess(entry AccessLogEntry) {
+	if ContextLambdaRequest(entry.Ctx) != nil {
+		fmt.Printf("%v %s%s - %d\n", entry.Duration, entry.Method, entry.Path, entry.StatusCode)
+		return
+	}
+	fmt.Printf("%v %s%s - %d %s\n", entry.Duration, entry.Method, entry.Path, entry.StatusCode, http.StatusText(entry.StatusCode))
+}
+
+type contextAccessLogStart struct{}
+
+// accessLogStart is the part of an access log entry recorded when a call
+// starts.
+type accessLogStart struct {
+	entry AccessLogEntry
+	start time.Time
+}
+
+// NewAccessLogHook returns a pair of hooks that log each call to logger, or to
+// standard output in the format the proxies used to if logger is nil. The middleware hook
+// records the start time and the request's method, path, client address and
+// request ID, and the post-request hook completes the entry with the response
+// status code and duration, and logs it. Add both to API.GlobalHooks to log
+// every call:
+//
+//	logStart, logEnd := dispatch.NewAccessLogHook(nil)
+//	api.GlobalHooks = append(api.GlobalHooks, logStart, dispatch.After(logEnd))
+//
+// The method and path are those of the request when the middleware hook runs,
+// so it should come after any hooks that rewrite them. If the post-request hook
+// is used without the middleware hook, the duration is measured from the start
+// of API.Call. OPTIONS preflight requests, which are answered by the proxies
+// without calling the API, are not logged. Neither are errors the proxies
+// produce outside the call, such as a failure to read the request body or to
+// marshal the response body; for the latter, the entry has the status of the
+// call's own result instead.
+func NewAccessLogHook(logger AccessLogger) (MiddlewareHook, PostRequestHook) {
+	if logger == nil {
+		logger = stdoutAccessLogger{}
+	}
+	start := func(input *EndpointInput) (*EndpointInput, error) {
+		input.Ctx = context.WithValue(input.Ctx, contextAccessLogStart{}, accessLogStart{
+			entry: newAccessLogEntry(input),
+			start: time.Now(),
+		})
+		return input, nil
+	}
+	end := func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		started, ok := input.Ctx.Value(contextAccessLogStart{}).(accessLogStart)
+		if !ok {
+			started = accessLogStart{entry: newAccessLogEntry(input), start: callStart(input.Ctx)}
+		}
+		entry := started.entry
+		entry.Ctx = input.Ctx
+		entry.StatusCode = responseStatus(out, err)
+		entry.Duration = time.Since(started.start)
+		logger.LogAccess(entry)
+		return out, err
+	}
+	return start, end
+}
+
+// newAccessLogEntry returns an access log entry with the request's metadata.
+func newAccessLogEntry(input *EndpointInput) AccessLogEntry {
+	return AccessLogEntry{
+		Method:     input.Method,
+		Path:       input.Path,
+		RemoteAddr: input.RemoteAddr,
+		RequestID:  ContextRequestID(input.Ctx),
+	}
+}
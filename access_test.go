@@ -0,0 +1,46 @@
+package dispatch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type testAccessLogger struct {
+	entries []AccessLogEntry
+}
+
+func (l *testAccessLogger) LogAccess(entry AccessLogEntry) {
+	l.entries = append(l.entries, entry)
+}
+
+func TestAccessLogHook(t *testing.T) {
+	logger := &testAccessLogger{}
+	logStart, logEnd := NewAccessLogHook(logger)
+	api := API{GlobalHooks: []MiddlewareHook{logStart, After(logEnd)}}
+	api.AddEndpoint("GET/slow", func() { time.Sleep(5 * time.Millisecond) })
+	api.AddEndpoint("GET/error", testAPIErrors)
+
+	req := httptest.NewRequest("GET", "/slow", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	api.HTTPProxy(httptest.NewRecorder(), req)
+	api.LambdaProxy("*")(&events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/error"})
+	api.HTTPProxy(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	if len(logger.entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %v", logger.entries)
+	}
+	slow := logger.entries[0]
+	if slow.Method != "GET" || slow.Path != "/slow" || slow.StatusCode != http.StatusOK || slow.RequestID != "req-1" || slow.RemoteAddr == "" || slow.Duration < 5*time.Millisecond {
+		t.Errorf("Unexpected entry %+v", slow)
+	}
+	if entry := logger.entries[1]; entry.Path != "/error" || entry.StatusCode != 418 || ContextLambdaRequest(entry.Ctx) == nil {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if entry := logger.entries[2]; entry.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+}
@@ -4,13 +4,84 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net/http"
 	"reflect"
+	"runtime"
 	"runtime/debug"
+	"strings"
 )
 
 // API is an object that holds all API methods and can dispatch them.
 type API struct {
 	Endpoints []*Endpoint
+
+	// GlobalHooks are middleware hooks that run for every call, in order,
+	// before the endpoint is matched and before any of the endpoint's own
+	// hooks. They can change the method and path used to match the endpoint,
+	// but path variables are not yet available to them.
+	GlobalHooks []MiddlewareHook
+
+	// Logger receives the API's log messages. If nil, messages are written
+	// with the standard log package.
+	Logger Logger
+
+	// RawJSONTypes lists the response content types for which a handler's
+	// json.RawMessage output is written as-is, without being marshalled again.
+	// If nil, it defaults to application/json.
+	RawJSONTypes []string
+
+	// TrustProxy makes EndpointInput.RemoteAddr use the client address in the
+	// X-Forwarded-For or X-Real-IP request headers, when the API is served
+	// behind a load balancer or reverse proxy that sets them. Clients can send
+	// these headers themselves, and proxies append to X-Forwarded-For rather
+	// than replacing it, so only the entries added by trusted proxies are
+	// used: the address is the entry TrustedProxies from the end. TrustProxy
+	// must only be set if every request comes through the proxies, and the
+	// proxies must overwrite any X-Real-IP header sent by clients.
+	TrustProxy bool
+
+	// TrustedProxies is the number of proxies in front of the API that append
+	// to X-Forwarded-For, such as 2 for a CDN in front of a load balancer. If
+	// zero, one proxy is assumed. It has no effect unless TrustProxy is set.
+	TrustedProxies int
+
+	// TruncateLargeResponses makes NewResponseSizeHook replace responses over
+	// its size limit with an error, instead of only logging a warning.
+	TruncateLargeResponses bool
+
+	frozen bool
+}
+
+// Logger is the interface used for log output. It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// trustedProxies returns the number of proxies whose X-Forwarded-For entries
+// are trusted, or zero if TrustProxy is not set.
+func (api *API) trustedProxies() int {
+	if !api.TrustProxy {
+		return 0
+	}
+	if api.TrustedProxies < 1 {
+		return 1
+	}
+	return api.TrustedProxies
+}
+
+// logger returns the API's Logger, or the standard logger if none is set.
+func (api *API) logger() Logger {
+	if api != nil && api.Logger != nil {
+		return api.Logger
+	}
+	return stdLogger{}
+}
+
+// stdLogger is a Logger that writes with the standard log package.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
 }
 
 // MatchEndpoint matches a request to an endpoint, creating a map of path
@@ -38,45 +109,130 @@ func (api *API) GetMethodsForPath(path string) []string {
 	return methods
 }
 
+// WarmUp calls the WarmUpFunc of each endpoint that has one, in the order the
+// endpoints were registered, to reduce the latency of the first requests after
+// a cold start. It stops at and returns the first error.
+func (api *API) WarmUp(ctx context.Context) error {
+	for _, endpt := range api.Endpoints {
+		if endpt.WarmUpFunc == nil {
+			continue
+		}
+		if err := endpt.WarmUpFunc(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MiddlewareOrder returns the names of the middleware hooks that would run for
+// a request with the given method and path, in the order that they would run,
+// without running them. Hook names are derived from their function names, so
+// hooks created by a factory such as NewHMACHook are listed as
+// dispatch.NewHMACHook.func1, and hooks combined with Chain are listed
+// individually. If no endpoint matches, only the global hooks are listed.
+func (api *API) MiddlewareOrder(method, path string) []string {
+	hooks := append([]MiddlewareHook{}, api.GlobalHooks...)
+	if endpoint, _ := api.MatchEndpoint(method, path); endpoint != nil {
+		hooks = append(hooks, endpoint.PreRequestHooks...)
+	}
+	names := make([]string, 0, len(hooks))
+	for _, hook := range hooks {
+		names = append(names, hookNames(hook)...)
+	}
+	return names
+}
+
+// funcName returns the package-qualified name of a function, such as a hook or
+// handler.
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
 // Call sends the input to the endpoint and returns the result.
 func (api *API) Call(ctx context.Context, method, path string, input json.RawMessage) (out interface{}, err error) {
 	// Recover from any panics, and return an internal error in that case
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("API.Call panic: %v\n", r)
+			api.logger().Printf("API.Call panic: %v\n", r)
 			debug.PrintStack()
 			out = nil
 			err = ErrInternal
 		}
 	}()
 
-	endpoint, pathVars := api.MatchEndpoint(method, path)
-	if endpoint == nil {
-		return nil, ErrNotFound
+	ctx, state := withCallState(ctx)
+	state.begin(api)
+	in := api.newEndpointInput(ctx, method, path, input)
+	return runHooks(state, api.GlobalHooks, in, func(in *EndpointInput) (interface{}, error) {
+		endpoint, pathVars := api.MatchEndpoint(in.Method, in.Path)
+		if endpoint == nil {
+			return nil, ErrNotFound
+		}
+		if err := endpoint.pathMatcher.ValidateVars(pathVars); err != nil {
+			return nil, err
+		}
+		in.Ctx = SetContextPathVars(in.Ctx, pathVars)
+		state.setEndpoint(endpoint)
+		if endpoint.Deprecated {
+			api.logger().Printf("Deprecated endpoint %s called\n", endpoint.Pattern)
+			sunset := ""
+			if !endpoint.DeprecationDate.IsZero() {
+				sunset = endpoint.DeprecationDate.UTC().Format(http.TimeFormat)
+			}
+			setDeprecationHeaders(in.Ctx, sunset, endpoint.SunsetURL, "sunset")
+		}
+
+		return runHooks(state, endpoint.PreRequestHooks, in, func(in *EndpointInput) (interface{}, error) {
+			out, err := api.callHandler(endpoint, in.Ctx, in.Input)
+			if response := state.takeResponse(); response != nil {
+				return response, nil
+			}
+			return out, err
+		})
+	})
+}
+
+// runHooks runs each hook in order and then calls final with the resulting
+// input, stopping early if a hook returns an error or the call is aborted.
+// Post-request hooks added by a hook wrap the rest of the chain, so that they
+// see its result.
+func runHooks(state *callState, hooks []MiddlewareHook, in *EndpointInput, final func(*EndpointInput) (interface{}, error)) (interface{}, error) {
+	if err := state.aborted(); err != nil {
+		return nil, err
+	}
+	if len(hooks) == 0 {
+		return final(in)
 	}
-	ctx = SetContextPathVars(ctx, pathVars)
 
-	for _, hook := range endpoint.PreRequestHooks {
-		originalInput := &EndpointInput{method, path, ctx, input}
-		modifiedInput, err := hook(originalInput)
-		if err != nil {
+	in, err := hooks[0](in)
+	next := func() (interface{}, error) {
+		return runHooks(state, hooks[1:], in, final)
+	}
+	if err != nil {
+		next = func() (interface{}, error) {
 			return nil, err
 		}
-		method = modifiedInput.Method
-		path = modifiedInput.Path
-		ctx = modifiedInput.Ctx
-		input = modifiedInput.Input
 	}
+	return state.wrapNext(next)()
+}
 
+// callHandler unmarshals the input for an endpoint's handler, calls it, and
+// interprets its return values.
+func (api *API) callHandler(endpoint *Endpoint, ctx context.Context, input json.RawMessage) (out interface{}, err error) {
 	handlerType := reflect.TypeOf(endpoint.Handler)
 	if handlerType.Kind() != reflect.Func {
-		log.Printf("Bad handler type for %s: %s\n", endpoint.Path, handlerType.Kind())
+		api.logger().Printf("Bad handler type for %s: %s\n", endpoint.Pattern, handlerType.Kind())
 		return nil, ErrInternal
 	}
 
 	// Handler functions can take a custom value type and/or a context input
 	if handlerType.NumIn() > 2 {
-		log.Printf("Handler %s takes too many args\n", endpoint.Path)
+		api.logger().Printf("Handler %s takes too many args\n", endpoint.Pattern)
 		return nil, ErrInternal
 	}
 	var inputType reflect.Type
@@ -87,14 +243,14 @@ func (api *API) Call(ctx context.Context, method, path string, input json.RawMes
 		ctxType := reflect.TypeOf((*context.Context)(nil)).Elem()
 		if inType.Implements(ctxType) {
 			if takesContext {
-				log.Printf("Handler %s takes multiple context inputs", endpoint.Path)
+				api.logger().Printf("Handler %s takes multiple context inputs\n", endpoint.Pattern)
 				return nil, ErrInternal
 			}
 			takesContext = true
 			ctxIndex = i
 		} else {
 			if takesCustom {
-				log.Printf("Handler %s takes multiple inputs", endpoint.Path)
+				api.logger().Printf("Handler %s takes multiple inputs\n", endpoint.Pattern)
 				return nil, ErrInternal
 			}
 			takesCustom = true
@@ -158,7 +314,7 @@ func (api *API) Call(ctx context.Context, method, path string, input json.RawMes
 		return out, err
 
 	default:
-		log.Printf("Handler %s returned too many values\n", endpoint.Path)
+		api.logger().Printf("Handler %s returned too many values\n", endpoint.Pattern)
 		return nil, ErrInternal
 	}
 }
@@ -0,0 +1,59 @@
+package dispatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// An AuditEntry records a single call for an audit trail. It includes a hash
+// of the request body rather than the body itself.
+type AuditEntry struct {
+	Timestamp time.Time
+	RequestID string
+	UserID    string
+	TenantID  string
+	Method    string
+	Path      string
+	InputHash string
+	Status    int
+	Latency   time.Duration
+}
+
+// An AuditSink stores audit entries, such as in a database, S3, or a message
+// queue.
+type AuditSink interface {
+	Record(entry AuditEntry) error
+}
+
+// NewAuditHook returns a middleware hook that records an AuditEntry in sink
+// for each call once it completes. The user ID is the sub claim of the JWT
+// claims in the context, and the tenant ID is that of the tenant stored by
+// NewTenantHook, so the hook should be added after any authentication or tenant
+// hooks. Errors from sink are logged, and do not fail the call.
+func NewAuditHook(sink AuditSink) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		start := time.Now()
+		hash := sha256.Sum256(input.Input)
+		userID, _ := ContextJWTClaims(input.Ctx)["sub"].(string)
+		entry := AuditEntry{
+			Timestamp: start,
+			RequestID: ContextRequestID(input.Ctx),
+			UserID:    userID,
+			TenantID:  ContextTenant(input.Ctx).ID,
+			Method:    input.Method,
+			Path:      input.Path,
+			InputHash: hex.EncodeToString(hash[:]),
+		}
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			out, err := next()
+			entry.Status = responseStatus(out, err)
+			entry.Latency = time.Since(start)
+			if sinkErr := sink.Record(entry); sinkErr != nil {
+				contextLogger(input.Ctx).Printf("Failed to record audit entry for %s %s: %v\n", entry.Method, entry.Path, sinkErr)
+			}
+			return out, err
+		})
+		return input, nil
+	}
+}
@@ -0,0 +1,52 @@
+package dispatch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// testAuditSink is an AuditSink that keeps its entries.
+type testAuditSink struct {
+	entries []AuditEntry
+	err     error
+}
+
+func (s *testAuditSink) Record(entry AuditEntry) error {
+	s.entries = append(s.entries, entry)
+	return s.err
+}
+
+func TestAuditHook(t *testing.T) {
+	sink := &testAuditSink{}
+	logger := &testLogger{}
+	api := API{Logger: logger}
+	api.AddEndpoint("POST/items", testAPIErrors, NewAuditHook(sink))
+
+	ctx := SetContextRequestID(context.Background(), "req-1")
+	ctx = SetContextJWTClaims(ctx, JWTClaims{"sub": "u1"})
+	ctx = SetContextTenant(ctx, Tenant{ID: "acme"})
+	body := []byte(`{"name":"x"}`)
+	api.Call(ctx, "POST", "/items", body)
+
+	if len(sink.entries) != 1 {
+		t.Fatalf("Expected one entry, got %d", len(sink.entries))
+	}
+	entry := sink.entries[0]
+	hash := sha256.Sum256(body)
+	if entry.RequestID != "req-1" || entry.UserID != "u1" || entry.TenantID != "acme" ||
+		entry.Method != "POST" || entry.Path != "/items" || entry.Status != 418 ||
+		entry.InputHash != hex.EncodeToString(hash[:]) || entry.Timestamp.IsZero() {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+
+	sink.err = errTemporary
+	if _, err := api.Call(ctx, "POST", "/items", body); ErrorStatusCode(err) != 418 {
+		t.Errorf("Expected sink error to be ignored, got %v", err)
+	}
+	if !strings.Contains(logger.String(), "Failed to record audit entry for POST /items") {
+		t.Errorf("Expected sink error to be logged, got %q", logger.String())
+	}
+}
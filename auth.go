@@ -0,0 +1,200 @@
+package dispatch
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// NewHMACHook returns a middleware hook that verifies signed requests, such as
+// webhooks from Stripe or GitHub. The hook computes the HMAC-SHA256 of the
+// request body using secret and compares it to the hex-encoded signature in
+// the headerName header, which may have a "sha256=" prefix. Requests with a
+// missing or incorrect signature fail with status 401.
+func NewHMACHook(secret, headerName string) MiddlewareHook {
+	key := []byte(secret)
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		signature := strings.TrimPrefix(input.Headers.Get(headerName), "sha256=")
+		got, err := hex.DecodeString(signature)
+		if signature == "" || err != nil {
+			return nil, NewAPIError(http.StatusUnauthorized, "invalid signature")
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input.Input)
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			return nil, NewAPIError(http.StatusUnauthorized, "invalid signature")
+		}
+		return input, nil
+	}
+}
+
+// NewAPIKeyHook returns a middleware hook that authenticates requests by the
+// API key in the headerName header, which defaults to X-API-Key. validKeys maps
+// each valid key to the name of its owner, which is stored in the context for
+// ContextAPIKeyOwner. Requests with a missing or unknown key fail with status
+// 401.
+func NewAPIKeyHook(validKeys map[string]string, headerName string) MiddlewareHook {
+	return NewAPIKeyHookFunc(func() map[string]string { return validKeys }, headerName)
+}
+
+// NewAPIKeyHookFunc is like NewAPIKeyHook, but calls keys on each request to get
+// the current set of valid keys, so that keys can be reloaded without
+// re-registering endpoints.
+func NewAPIKeyHookFunc(keys func() map[string]string, headerName string) MiddlewareHook {
+	if headerName == "" {
+		headerName = "X-API-Key"
+	}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		key := input.Headers.Get(headerName)
+		owner, ok := keys()[key]
+		if key == "" || !ok {
+			return nil, NewAPIError(http.StatusUnauthorized, "invalid api key")
+		}
+		input.Ctx = SetContextAPIKeyOwner(input.Ctx, owner)
+		return input, nil
+	}
+}
+
+// NewIPAllowlistHook returns a middleware hook that only allows requests from
+// clients whose address is within one of allowedCIDRs, such as 10.0.0.0/8.
+// Plain IP addresses are also accepted. Other requests fail with status 403.
+//
+// The CIDR blocks are parsed when the hook is created, and an error is returned
+// if any of them is invalid. The allowed blocks are logged with the API's
+// Logger when the hook first runs.
+func NewIPAllowlistHook(allowedCIDRs []string) (MiddlewareHook, error) {
+	networks := make([]*net.IPNet, 0, len(allowedCIDRs))
+	for _, cidr := range allowedCIDRs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	var logOnce sync.Once
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		logOnce.Do(func() {
+			contextLogger(input.Ctx).Printf("IP allowlist: %v\n", networks)
+		})
+		ip := net.ParseIP(input.RemoteAddr)
+		if ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					return input, nil
+				}
+			}
+		}
+		return nil, NewAPIError(http.StatusForbidden, "forbidden")
+	}, nil
+}
+
+// MustNewIPAllowlistHook is like NewIPAllowlistHook, but panics if any of
+// allowedCIDRs is invalid.
+func MustNewIPAllowlistHook(allowedCIDRs []string) MiddlewareHook {
+	hook, err := NewIPAllowlistHook(allowedCIDRs)
+	if err != nil {
+		panic(fmt.Sprintf("dispatch: invalid IP allowlist: %v", err))
+	}
+	return hook
+}
+
+// NewAuthzHook returns a middleware hook that authorizes each request with
+// authorizer, which is passed the request context, method, path, and the JWT
+// claims stored by an earlier authentication hook. Requests for which
+// authorizer returns false fail with status 403. This allows role-based or
+// attribute-based policies to be applied without a specific hook for each
+// endpoint, so the hook should be added after the authentication hook.
+func NewAuthzHook(authorizer func(ctx context.Context, method, path string, claims JWTClaims) bool) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if !authorizer(input.Ctx, input.Method, input.Path, ContextJWTClaims(input.Ctx)) {
+			return nil, NewAPIError(http.StatusForbidden, "forbidden")
+		}
+		return input, nil
+	}
+}
+
+// NewCSRFHook returns a middleware hook that protects browser-facing endpoints
+// from cross-site request forgery, using the double-submit cookie pattern. The
+// value of the tokenHeader request header must match the value of the
+// cookieName cookie, and requests where either is missing or they differ fail
+// with status 403. GET, HEAD, and OPTIONS requests are exempt.
+func NewCSRFHook(tokenHeader, cookieName string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		switch input.Method {
+		case "GET", "HEAD", "OPTIONS":
+			return input, nil
+		}
+		token := input.Headers.Get(tokenHeader)
+		cookie := input.Cookies[cookieName]
+		if token == "" || cookie == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cookie)) != 1 {
+			return nil, NewAPIError(http.StatusForbidden, "CSRF token mismatch")
+		}
+		return input, nil
+	}
+}
+
+// A User is an application's representation of an authenticated user, as
+// loaded by NewUserContextHook.
+type User interface{}
+
+// NewUserContextHook returns a middleware hook that loads the user identified
+// by the sub claim of the JWT claims in the context, and stores it in the
+// context, where handlers can read it with ContextUser. The hook must be added
+// after the authentication hook that stores the claims. Requests without a sub
+// claim, or for which loader returns a nil user or an error wrapping
+// ErrNotFound, fail with status 401. Other errors from loader are returned
+// as-is.
+func NewUserContextHook(loader func(context.Context, string) (User, error)) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		userID, _ := ContextJWTClaims(input.Ctx)["sub"].(string)
+		if userID == "" {
+			return nil, NewAPIError(http.StatusUnauthorized, "user not found")
+		}
+		user, err := loader(input.Ctx, userID)
+		if errors.Is(err, ErrNotFound) || (err == nil && user == nil) {
+			return nil, NewAPIError(http.StatusUnauthorized, "user not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		input.Ctx = SetContextUser(input.Ctx, user)
+		return input, nil
+	}
+}
+
+// NewOwnershipHook returns a middleware hook that only allows the owner of a
+// resource to access it, such as only allowing a user to update
+// /users/{id} with their own ID. ownerFn is passed the request context, with
+// any JWT claims, and the path variables, and reports whether the caller owns
+// the resource. Requests from other callers fail with status 403, and requests
+// for which ownerFn returns an error fail with status 500. The error is
+// logged, but not sent to the client.
+func NewOwnershipHook(ownerFn func(ctx context.Context, pathVars PathVars) (bool, error)) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		owner, err := ownerFn(input.Ctx, ContextPathVars(input.Ctx))
+		if err != nil {
+			contextLogger(input.Ctx).Printf("Ownership check for %s %s failed: %v\n", input.Method, input.Path, err)
+			return nil, NewAPIError(http.StatusInternalServerError, "internal error")
+		}
+		if !owner {
+			return nil, NewAPIError(http.StatusForbidden, "forbidden")
+		}
+		return input, nil
+	}
+}
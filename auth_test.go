@@ -0,0 +1,189 @@
+package dispatch
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHMACHook(t *testing.T) {
+	hook := NewHMACHook("secret", "X-Signature")
+	body := []byte(`{"event":"paid"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	for _, header := range []string{signature, "sha256=" + signature} {
+		input := &EndpointInput{Ctx: context.Background(), Input: body, Headers: http.Header{}}
+		input.Headers.Set("X-Signature", header)
+		if _, err := hook(input); err != nil {
+			t.Errorf("Signature %s: %v", header, err)
+		}
+	}
+
+	for _, header := range []string{"", "zz", hex.EncodeToString([]byte("wrong"))} {
+		input := &EndpointInput{Ctx: context.Background(), Input: body, Headers: http.Header{}}
+		input.Headers.Set("X-Signature", header)
+		_, err := hook(input)
+		if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Signature %q: expected 401, got %v", header, err)
+		}
+	}
+}
+
+func TestAPIKeyHook(t *testing.T) {
+	keys := map[string]string{"key1": "alice"}
+	hook := NewAPIKeyHookFunc(func() map[string]string { return keys }, "")
+
+	input := &EndpointInput{Ctx: context.Background(), Headers: http.Header{}}
+	input.Headers.Set("X-API-Key", "key1")
+	input, err := hook(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := ContextAPIKeyOwner(input.Ctx); owner != "alice" {
+		t.Errorf("Expected owner alice, got %s", owner)
+	}
+
+	// Reloaded keys take effect on the next request
+	keys = map[string]string{"key2": "bob"}
+	input = &EndpointInput{Ctx: context.Background(), Headers: http.Header{}}
+	input.Headers.Set("X-API-Key", "key1")
+	_, err = hook(input)
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %v", err)
+	}
+}
+
+func TestIPAllowlistHook(t *testing.T) {
+	hook, err := NewIPAllowlistHook([]string{"10.0.0.0/8", "192.168.1.5", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []string{"10.1.2.3", "192.168.1.5", "::1"} {
+		if _, err := hook(&EndpointInput{RemoteAddr: addr}); err != nil {
+			t.Errorf("Address %s: %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"11.0.0.1", "192.168.1.6", ""} {
+		_, err := hook(&EndpointInput{RemoteAddr: addr})
+		if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusForbidden {
+			t.Errorf("Address %q: expected 403, got %v", addr, err)
+		}
+	}
+
+	if _, err := NewIPAllowlistHook([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected MustNewIPAllowlistHook to panic for invalid CIDR")
+		}
+	}()
+	MustNewIPAllowlistHook([]string{"not-an-ip"})
+}
+
+func TestAuthzHook(t *testing.T) {
+	hook := NewAuthzHook(func(ctx context.Context, method, path string, claims JWTClaims) bool {
+		return method == "GET" || claims["role"] == "admin"
+	})
+	api := API{}
+	api.AddEndpoint("GET,DELETE/items/{id}", func() {}, hook)
+
+	if _, err := api.Call(context.Background(), "GET", "/items/1", nil); err != nil {
+		t.Error(err)
+	}
+	_, err := api.Call(context.Background(), "DELETE", "/items/1", nil)
+	if ErrorStatusCode(err) != http.StatusForbidden {
+		t.Errorf("Expected 403, got %v", err)
+	}
+	ctx := SetContextJWTClaims(context.Background(), JWTClaims{"role": "admin"})
+	if _, err := api.Call(ctx, "DELETE", "/items/1", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCSRFHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET,POST/form", func() {}, NewCSRFHook("X-CSRF-Token", "csrf"))
+
+	request := func(method, token, cookie string) int {
+		req := httptest.NewRequest(method, "/form", nil)
+		if token != "" {
+			req.Header.Set("X-CSRF-Token", token)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "csrf", Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, req)
+		return rec.Code
+	}
+
+	if code := request("GET", "", ""); code != http.StatusOK {
+		t.Errorf("Expected GET to be exempt, got %d", code)
+	}
+	if code := request("POST", "abc", "abc"); code != http.StatusOK {
+		t.Errorf("Expected matching token to pass, got %d", code)
+	}
+	for _, pair := range [][2]string{{"abc", "xyz"}, {"", "abc"}, {"abc", ""}} {
+		if code := request("POST", pair[0], pair[1]); code != http.StatusForbidden {
+			t.Errorf("Token %q, cookie %q: expected 403, got %d", pair[0], pair[1], code)
+		}
+	}
+}
+
+func TestUserContextHook(t *testing.T) {
+	type user struct{ Name string }
+	loader := func(ctx context.Context, userID string) (User, error) {
+		if userID == "u1" {
+			return &user{Name: "alice"}, nil
+		}
+		return nil, ErrNotFound
+	}
+	handler := func(ctx context.Context) string {
+		return ContextUser(ctx).(*user).Name
+	}
+	api := API{}
+	api.AddEndpoint("GET/me", handler, NewUserContextHook(loader))
+
+	ctx := SetContextJWTClaims(context.Background(), JWTClaims{"sub": "u1"})
+	if out, err := api.Call(ctx, "GET", "/me", nil); out != "alice" || err != nil {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+	for _, claims := range []JWTClaims{{"sub": "u2"}, {}} {
+		ctx := SetContextJWTClaims(context.Background(), claims)
+		if _, err := api.Call(ctx, "GET", "/me", nil); ErrorStatusCode(err) != http.StatusUnauthorized {
+			t.Errorf("Claims %v: expected 401, got %v", claims, err)
+		}
+	}
+}
+
+func TestOwnershipHook(t *testing.T) {
+	ownerFn := func(ctx context.Context, pathVars PathVars) (bool, error) {
+		if pathVars["id"] == "error" {
+			return false, errTemporary
+		}
+		return ContextJWTClaims(ctx)["sub"] == pathVars["id"], nil
+	}
+	logger := &testLogger{}
+	api := API{Logger: logger}
+	api.AddEndpoint("PUT/users/{id}", func() {}, NewOwnershipHook(ownerFn))
+
+	ctx := SetContextJWTClaims(context.Background(), JWTClaims{"sub": "u1"})
+	for path, expected := range map[string]int{"/users/u1": 200, "/users/u2": 403, "/users/error": 500} {
+		_, err := api.Call(ctx, "PUT", path, nil)
+		if code := responseStatus(nil, err); code != expected {
+			t.Errorf("%s: expected %d, got %v", path, expected, err)
+		}
+	}
+	if !strings.Contains(logger.String(), "Ownership check for PUT /users/error failed") {
+		t.Errorf("Expected owner error to be logged, got %q", logger.String())
+	}
+}
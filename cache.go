@@ -0,0 +1,94 @@
+package dispatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewETagHook returns a post-request hook that sets an ETag response header on
+// successful responses, computed from the response data by hasher, or from the
+// SHA-256 hash of its JSON encoding if hasher is nil. If a GET or HEAD request
+// has an If-None-Match header that matches the ETag, the response is replaced
+// with an empty one with status 304, so that the client can use its cached
+// copy. Add the hook to an endpoint with After.
+func NewETagHook(hasher func(data interface{}) string) PostRequestHook {
+	if hasher == nil {
+		hasher = jsonHash
+	}
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil || responseStatus(out, err) != http.StatusOK {
+			return out, err
+		}
+		data := out
+		switch resp := out.(type) {
+		case *http.Response, responseWritten:
+			return out, err
+		case *Response:
+			data = resp.Body
+		}
+		etag := hasher(data)
+		if etag == "" {
+			return out, err
+		}
+		if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+			etag = `"` + etag + `"`
+		}
+		SetResponseHeader(input.Ctx, "ETag", etag)
+		if (input.Method == "GET" || input.Method == "HEAD") && etagMatches(input.Headers.Get("If-None-Match"), etag) {
+			return &Response{StatusCode: http.StatusNotModified}, nil
+		}
+		return out, err
+	}
+}
+
+// jsonHash returns the hex-encoded SHA-256 hash of the JSON encoding of data,
+// or an empty string if it cannot be encoded.
+func jsonHash(data interface{}) string {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:])
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison that RFC 7232 specifies for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// NewCacheHeaderHook returns a post-request hook that lets clients cache the
+// successful responses of read-only endpoints for maxAge, by setting the
+// Cache-Control header to "public, max-age=N" if isPublic is true, or
+// "private, max-age=N" otherwise, and the Expires header to maxAge from now.
+// Error responses are sent without them. Add the hook to an endpoint with
+// After.
+func NewCacheHeaderHook(maxAge time.Duration, isPublic bool) PostRequestHook {
+	scope := "private"
+	if isPublic {
+		scope = "public"
+	}
+	directive := scope + ", max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return out, err
+		}
+		SetResponseHeader(input.Ctx, "Cache-Control", directive)
+		SetResponseHeader(input.Ctx, "Expires", time.Now().Add(maxAge).UTC().Format(http.TimeFormat))
+		return out, err
+	}
+}
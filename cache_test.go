@@ -0,0 +1,66 @@
+package dispatch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETagHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/item", func() string { return "x" }, After(NewETagHook(nil)))
+	api.AddEndpoint("GET/custom", func() string { return "x" }, After(NewETagHook(func(interface{}) string { return "v1" })))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/item", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || len(etag) != 66 || rec.Body.String() != `"x"` {
+		t.Fatalf("Unexpected response %d %q %s", rec.Code, etag, rec.Body)
+	}
+
+	for _, ifNoneMatch := range []string{etag, `"other", W/` + etag, "*"} {
+		req := httptest.NewRequest("GET", "/item", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		rec = httptest.NewRecorder()
+		api.HTTPProxy(rec, req)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: expected empty 304, got %d %s", ifNoneMatch, rec.Code, rec.Body)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/custom", nil)
+	req.Header.Set("If-None-Match", `"v0"`)
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"v1"` {
+		t.Errorf("Unexpected response %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCacheHeaderHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/public", func() string { return "a" }, After(NewCacheHeaderHook(time.Hour, true)))
+	api.AddEndpoint("GET/private", func() string { return "a" }, After(NewCacheHeaderHook(90*time.Second, false)))
+	api.AddEndpoint("GET/error", testAPIErrors, After(NewCacheHeaderHook(time.Hour, true)))
+
+	for path, expected := range map[string]string{
+		"/public":  "public, max-age=3600",
+		"/private": "private, max-age=90",
+		"/error":   "",
+	} {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("GET", path, nil))
+		if v := rec.Header().Get("Cache-Control"); v != expected {
+			t.Errorf("%s: expected Cache-Control %q, got %q", path, expected, v)
+		}
+		expires, err := http.ParseTime(rec.Header().Get("Expires"))
+		if expected == "" {
+			if err == nil {
+				t.Errorf("%s: unexpected Expires header", path)
+			}
+		} else if err != nil || expires.Before(time.Now()) {
+			t.Errorf("%s: unexpected Expires %q", path, rec.Header().Get("Expires"))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package dispatch
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// circuit tracks the recent failures of one endpoint.
+type circuit struct {
+	failures int
+	open     bool
+	openedAt time.Time
+	// trial is set while the single call allowed by a half-open circuit runs.
+	trial bool
+}
+
+// NewCircuitBreakerHook returns a middleware hook that stops calling an
+// endpoint's handler once it keeps failing. Each endpoint using the hook has
+// its own circuit, and calls that fail with a 5xx status count as failures.
+//
+// When an endpoint's consecutive failures exceed threshold, its circuit opens,
+// and calls fail immediately with status 503. After resetAfter, the circuit is
+// half-open and allows a single trial call: if it succeeds the circuit closes,
+// and if it fails or panics the circuit opens again.
+//
+// The hook must be added to endpoints rather than to API.GlobalHooks, since
+// global hooks run before an endpoint is matched. It has no effect there.
+func NewCircuitBreakerHook(threshold int, resetAfter time.Duration) MiddlewareHook {
+	var mu sync.Mutex
+	circuits := make(map[string]*circuit)
+
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		endpoint := ContextEndpoint(input.Ctx)
+		if endpoint == nil {
+			return input, nil
+		}
+		key := endpoint.Path
+
+		mu.Lock()
+		c := circuits[key]
+		if c == nil {
+			c = &circuit{}
+			circuits[key] = c
+		}
+		if c.open {
+			if c.trial || time.Since(c.openedAt) < resetAfter {
+				mu.Unlock()
+				return nil, NewAPIError(http.StatusServiceUnavailable, "service unavailable")
+			}
+			c.trial = true
+		}
+		mu.Unlock()
+
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (out interface{}, err error) {
+			// A panic counts as a failure, so that a half-open circuit is not
+			// left waiting for a trial call that never completes
+			failed := true
+			defer func() {
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case c.trial && failed:
+					c.trial = false
+					c.openedAt = time.Now()
+				case c.trial:
+					c.trial = false
+					c.open = false
+					c.failures = 0
+				case failed:
+					c.failures++
+					if c.failures > threshold {
+						c.open = true
+						c.openedAt = time.Now()
+					}
+				default:
+					c.failures = 0
+				}
+			}()
+			out, err = next()
+			failed = responseStatus(out, err) >= http.StatusInternalServerError
+			return out, err
+		})
+		return input, nil
+	}
+}
@@ -0,0 +1,92 @@
+package dispatch
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerHook(t *testing.T) {
+	failing := true
+	calls := 0
+	handler := func() error {
+		calls++
+		if failing {
+			return ErrInternal
+		}
+		return nil
+	}
+
+	api := API{}
+	api.AddEndpoint("GET/flaky", handler, NewCircuitBreakerHook(2, 20*time.Millisecond))
+	call := func() error {
+		_, err := api.Call(context.Background(), "GET", "/flaky", nil)
+		return err
+	}
+	isUnavailable := func(err error) bool {
+		apiErr, ok := err.(*APIError)
+		return ok && apiErr.StatusCode == http.StatusServiceUnavailable
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := call(); err != ErrInternal {
+			t.Errorf("Call %d: expected internal error, got %v", i, err)
+		}
+	}
+	if err := call(); !isUnavailable(err) || calls != 3 {
+		t.Errorf("Expected open circuit, got %v after %d calls", err, calls)
+	}
+
+	// Half-open: a failed trial reopens the circuit
+	time.Sleep(25 * time.Millisecond)
+	if err := call(); err != ErrInternal {
+		t.Errorf("Expected trial call to run, got %v", err)
+	}
+	if err := call(); !isUnavailable(err) {
+		t.Errorf("Expected reopened circuit, got %v", err)
+	}
+
+	// A successful trial closes it
+	time.Sleep(25 * time.Millisecond)
+	failing = false
+	for i := 0; i < 2; i++ {
+		if err := call(); err != nil {
+			t.Errorf("Call %d: expected closed circuit, got %v", i, err)
+		}
+	}
+}
+
+func TestCircuitBreakerHookTrialPanic(t *testing.T) {
+	panics := true
+	api := API{}
+	api.AddEndpoint("GET/flaky", func() error {
+		if panics {
+			panic("handler failed")
+		}
+		return nil
+	}, NewCircuitBreakerHook(0, 10*time.Millisecond))
+
+	// The first panic opens the circuit, and the trial call panics too
+	api.Call(context.Background(), "GET", "/flaky", nil)
+	time.Sleep(15 * time.Millisecond)
+	api.Call(context.Background(), "GET", "/flaky", nil)
+
+	// The circuit allows another trial once it can be half-open again
+	panics = false
+	time.Sleep(15 * time.Millisecond)
+	if _, err := api.Call(context.Background(), "GET", "/flaky", nil); err != nil {
+		t.Errorf("Expected trial call to be allowed after a panic, got %v", err)
+	}
+}
+
+func TestCircuitBreakerHookGlobal(t *testing.T) {
+	api := API{GlobalHooks: []MiddlewareHook{NewCircuitBreakerHook(0, time.Hour)}}
+	api.AddEndpoint("GET/fail", func() error { return ErrInternal })
+
+	for i := 0; i < 3; i++ {
+		if _, err := api.Call(context.Background(), "GET", "/fail", nil); err != ErrInternal {
+			t.Errorf("Expected global hook to have no effect, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package dispatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// An encodedBody is a response body encoded by a hook such as NewGzipHook, for
+// the proxies to write in place of the marshalled output.
+type encodedBody struct {
+	data            []byte
+	contentEncoding string
+}
+
+// NewGzipHook returns a post-request hook that compresses successful responses
+// with gzip, for requests with an Accept-Encoding header that allows it. It
+// works with both HTTPProxy and LambdaProxy, which write the compressed body
+// with a Content-Encoding header, and base64 encode it for API Gateway.
+// Responses from API.Call used directly, including calls made from inside
+// another call's handler, are not compressed. Upstream *http.Response outputs
+// are not compressed either.
+//
+// Add the hook to an endpoint with After, before any other post-request hooks,
+// so that it runs last and compresses their output.
+func NewGzipHook() PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		proxy := contextCallState(input.Ctx).proxyState()
+		if err != nil || proxy == nil || !acceptsGzip(input.Headers.Get("Accept-Encoding")) {
+			return out, err
+		}
+		body := out
+		switch resp := out.(type) {
+		case *http.Response, responseWritten:
+			return out, err
+		case *Response:
+			if resp == nil {
+				return out, err
+			}
+			body = resp.Body
+		}
+		if body == nil {
+			return out, err
+		}
+
+		data, ok := body.(json.RawMessage)
+		if !ok {
+			if data, err = json.Marshal(body); err != nil {
+				return nil, err
+			}
+		}
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		proxy.setEncodedBody(&encodedBody{data: compressed.Bytes(), contentEncoding: "gzip"})
+		return out, nil
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		params = strings.TrimSpace(params)
+		if !strings.HasPrefix(params, "q=") {
+			return true
+		}
+		q, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+		return err == nil && q > 0
+	}
+	return false
+}
+
+// takeEncodedBody returns the body encoded by a hook during the call with ctx,
+// if any, setting the headers that describe its encoding.
+func takeEncodedBody(ctx context.Context, header headerSetter) ([]byte, bool) {
+	proxy := contextProxyState(ctx)
+	if proxy == nil {
+		return nil, false
+	}
+	body := proxy.takeEncodedBody()
+	if body == nil {
+		return nil, false
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	header.Set("Content-Encoding", body.contentEncoding)
+	addVary(header, "Accept-Encoding")
+	return body.data, true
+}
@@ -0,0 +1,107 @@
+package dispatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func gunzip(t *testing.T, data []byte) string {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decompressed)
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, expected := range map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, gzip;q=1": true,
+		"br, *":             true,
+		"gzip;q=0":          false,
+		"identity":          false,
+	} {
+		if got := acceptsGzip(header); got != expected {
+			t.Errorf("Accept-Encoding %q: expected %v, got %v", header, expected, got)
+		}
+	}
+}
+
+func TestGzipHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/items", func() []string { return []string{"a", "b"} }, After(NewGzipHook()))
+	api.AddEndpoint("GET/created", func() *Response {
+		return &Response{StatusCode: http.StatusCreated, Body: "new"}
+	}, After(NewGzipHook()))
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected headers %v", rec.Header())
+	}
+	if body := gunzip(t, rec.Body.Bytes()); body != `["a","b"]` {
+		t.Errorf("Unexpected body %s", body)
+	}
+
+	req = httptest.NewRequest("GET", "/created", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if rec.Code != http.StatusCreated || gunzip(t, rec.Body.Bytes()) != `"new"` {
+		t.Errorf("Unexpected response %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/items", nil))
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != `["a","b"]` {
+		t.Errorf("Expected uncompressed response, got %v %s", rec.Header(), rec.Body)
+	}
+
+	res, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       "/items",
+		Headers:    map[string]string{"Accept-Encoding": "gzip"},
+	})
+	data, err := base64.StdEncoding.DecodeString(res.Body)
+	if err != nil || !res.IsBase64Encoded || res.Headers["Content-Encoding"] != "gzip" || gunzip(t, data) != `["a","b"]` {
+		t.Errorf("Unexpected Lambda response %+v", res)
+	}
+}
+
+func TestGzipHookCORSVary(t *testing.T) {
+	api := API{}
+	policy := CORSPolicy{AllowOrigins: []string{"https://a.example"}}
+	api.AddEndpoint("GET/items", func() []string { return []string{"a"} }, NewCORSHook(policy), After(NewGzipHook()))
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("Origin", "https://a.example")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if vary := rec.Header().Get("Vary"); vary != "Origin, Accept-Encoding" {
+		t.Errorf("Expected Vary to list both fields, got %q", vary)
+	}
+
+	resp, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       "/items",
+		Headers:    map[string]string{"Origin": "https://a.example", "Accept-Encoding": "gzip"},
+	})
+	if vary := resp.Headers["Vary"]; vary != "Origin, Accept-Encoding" {
+		t.Errorf("Expected Lambda Vary to list both fields, got %q", vary)
+	}
+}
@@ -0,0 +1,204 @@
+package dispatch
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keyedLocks holds a lock for each key in use, removing it once no call holds
+// or waits for it.
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// acquire locks key, waiting at most timeout, or until done is closed. If
+// timeout is zero or negative, it waits until done is closed. It returns false
+// if the lock could not be acquired.
+func (k *keyedLocks) acquire(key string, timeout time.Duration, done <-chan struct{}) bool {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyLock{ch: make(chan struct{}, 1)}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case lock.ch <- struct{}{}:
+		return true
+	case <-expired:
+	case <-done:
+	}
+	k.unref(key, lock)
+	return false
+}
+
+// release unlocks key, which must have been locked with acquire.
+func (k *keyedLocks) release(key string) {
+	k.mu.Lock()
+	lock := k.locks[key]
+	k.mu.Unlock()
+	<-lock.ch
+	k.unref(key, lock)
+}
+
+func (k *keyedLocks) unref(key string, lock *keyLock) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(k.locks, key)
+	}
+}
+
+// NewMutexHook returns a middleware hook that prevents calls with the same key
+// from running at the same time, such as two updates to the same resource. Each
+// call holds a lock for its key for the rest of the call, including the
+// handler, and releases it once the call completes, even if the handler or a
+// later hook panics, including one in the same Chain. A call that cannot
+// acquire the lock within timeout fails with status 409. If timeout is zero or
+// negative, calls wait until their context is done.
+func NewMutexHook(key func(*EndpointInput) string, timeout time.Duration) MiddlewareHook {
+	locks := &keyedLocks{locks: make(map[string]*keyLock)}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		k := key(input)
+		if !locks.acquire(k, timeout, input.Ctx.Done()) {
+			return nil, NewAPIError(http.StatusConflict, "resource is busy")
+		}
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			defer locks.release(k)
+			return next()
+		})
+		return input, nil
+	}
+}
+
+// debounceBatch is a group of calls with the same key, of which only the last
+// runs.
+type debounceBatch struct {
+	latest int
+	done   chan struct{}
+	out    interface{}
+	err    error
+}
+
+// NewDebouncedHook returns a middleware hook that coalesces rapid calls with
+// the same key, such as repeated saves of a draft. Each call waits for d, and
+// if another call with the same key arrives in that time, it is superseded.
+// Only the last call in a batch runs the rest of the call, including the
+// handler, and every call in the batch returns its result. This adds d to the
+// latency of every call, so it is only suitable for endpoints where the latest
+// input replaces earlier ones.
+func NewDebouncedHook(d time.Duration, key func(*EndpointInput) string) MiddlewareHook {
+	var mu sync.Mutex
+	batches := make(map[string]*debounceBatch)
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		k := key(input)
+		mu.Lock()
+		batch, ok := batches[k]
+		if !ok {
+			batch = &debounceBatch{done: make(chan struct{})}
+			batches[k] = batch
+		}
+		batch.latest++
+		call := batch.latest
+		mu.Unlock()
+
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (out interface{}, err error) {
+			time.Sleep(d)
+			mu.Lock()
+			superseded := batch.latest != call
+			if !superseded {
+				delete(batches, k)
+			}
+			mu.Unlock()
+
+			if superseded {
+				select {
+				case <-batch.done:
+					return batch.out, batch.err
+				case <-input.Ctx.Done():
+					return nil, input.Ctx.Err()
+				}
+			}
+			// Superseded calls get an internal error if the handler panics
+			batch.err = ErrInternal
+			defer close(batch.done)
+			out, err = next()
+			batch.out, batch.err = out, err
+			return out, err
+		})
+		return input, nil
+	}
+}
+
+// NewBulkheadHook returns a middleware hook that limits the number of calls
+// running the rest of the call at once to maxConcurrent, so that a slow
+// endpoint cannot use up the resources of the whole API. When the limit is
+// reached, calls wait up to waitTimeout for another to complete, and then
+// fail with status 429. If waitTimeout is zero or negative, they fail
+// immediately.
+//
+// NewBulkheadHook returns an error if maxConcurrent is less than 1.
+func NewBulkheadHook(maxConcurrent int, waitTimeout time.Duration) (MiddlewareHook, error) {
+	if maxConcurrent < 1 {
+		return nil, fmt.Errorf("invalid bulkhead limit: %d concurrent calls", maxConcurrent)
+	}
+	slots := make(chan struct{}, maxConcurrent)
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if !waitForSlot(input, slots, waitTimeout) {
+				return nil, NewAPIError(http.StatusTooManyRequests, "too many concurrent requests")
+			}
+		}
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			defer func() { <-slots }()
+			return next()
+		})
+		return input, nil
+	}, nil
+}
+
+// MustNewBulkheadHook is like NewBulkheadHook, but panics if maxConcurrent is
+// less than 1.
+func MustNewBulkheadHook(maxConcurrent int, waitTimeout time.Duration) MiddlewareHook {
+	hook, err := NewBulkheadHook(maxConcurrent, waitTimeout)
+	if err != nil {
+		panic("dispatch: " + err.Error())
+	}
+	return hook
+}
+
+// waitForSlot waits up to timeout to take a slot in slots, returning false if
+// none is free in time or the call's context is done.
+func waitForSlot(input *EndpointInput, slots chan struct{}, timeout time.Duration) bool {
+	if timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-input.Ctx.Done():
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package dispatch
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMutexHook(t *testing.T) {
+	var running, maxRunning int32
+	handler := func() {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}
+	byPath := func(input *EndpointInput) string { return input.Path }
+	api := API{}
+	api.AddEndpoint("PUT/users/{id}", handler, NewMutexHook(byPath, 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			api.Call(context.Background(), "PUT", "/users/1", nil)
+		}()
+	}
+	wg.Wait()
+	if maxRunning != 1 {
+		t.Errorf("Expected calls to be serialized, got %d at once", maxRunning)
+	}
+}
+
+func TestMutexHookChainPanic(t *testing.T) {
+	byPath := func(input *EndpointInput) string { return input.Path }
+	panicHook := func(input *EndpointInput) (*EndpointInput, error) {
+		if input.Method == "POST" {
+			panic("hook failed")
+		}
+		return input, nil
+	}
+	api := API{}
+	api.AddEndpoint("POST,PUT/users/{id}", func() {}, Chain(NewMutexHook(byPath, 10*time.Millisecond), panicHook))
+
+	if _, err := api.Call(context.Background(), "POST", "/users/1", nil); err != ErrInternal {
+		t.Errorf("Expected internal error from the panic, got %v", err)
+	}
+	if _, err := api.Call(context.Background(), "PUT", "/users/1", nil); err != nil {
+		t.Errorf("Expected the lock to be released after the panic, got %v", err)
+	}
+}
+
+func TestMutexHookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := func(ctx context.Context) {
+		if ContextPathVars(ctx)["id"] == "panic" {
+			panic("handler failed")
+		}
+		close(started)
+		<-release
+	}
+	key := func(input *EndpointInput) string { return "shared" }
+	api := API{}
+	api.AddEndpoint("PUT/users/{id}", handler, NewMutexHook(key, 10*time.Millisecond))
+
+	// A panicking handler must still release the lock
+	if _, err := api.Call(context.Background(), "PUT", "/users/panic", nil); err != ErrInternal {
+		t.Fatalf("Expected internal error, got %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		api.Call(context.Background(), "PUT", "/users/1", nil)
+		close(done)
+	}()
+	<-started
+	_, err := api.Call(context.Background(), "PUT", "/users/2", nil)
+	if ErrorStatusCode(err) != http.StatusConflict {
+		t.Errorf("Expected 409 while locked, got %v", err)
+	}
+	close(release)
+	<-done
+}
+
+func TestDebouncedHook(t *testing.T) {
+	var mu sync.Mutex
+	var saved []string
+	handler := func(draft string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = append(saved, draft)
+		return draft
+	}
+	key := func(input *EndpointInput) string { return input.Path }
+	api := API{}
+	api.AddEndpoint("PUT/drafts/{id}", handler, NewDebouncedHook(30*time.Millisecond, key))
+
+	results := make([]interface{}, 3)
+	var wg sync.WaitGroup
+	for i, draft := range []string{`"a"`, `"ab"`, `"abc"`} {
+		wg.Add(1)
+		go func(i int, draft string) {
+			defer wg.Done()
+			results[i], _ = api.Call(context.Background(), "PUT", "/drafts/1", []byte(draft))
+		}(i, draft)
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+
+	if len(saved) != 1 || saved[0] != "abc" {
+		t.Errorf("Expected only the last draft to be saved, got %v", saved)
+	}
+	for i, result := range results {
+		if result != "abc" {
+			t.Errorf("Call %d returned %v", i, result)
+		}
+	}
+}
+
+func TestBulkheadHook(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	handler := func() {
+		started <- struct{}{}
+		<-release
+	}
+	api := API{}
+	api.AddEndpoint("GET/slow", handler, MustNewBulkheadHook(2, 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			api.Call(context.Background(), "GET", "/slow", nil)
+		}()
+	}
+	<-started
+	<-started
+	_, err := api.Call(context.Background(), "GET", "/slow", nil)
+	if ErrorStatusCode(err) != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 when full, got %v", err)
+	}
+	close(release)
+	wg.Wait()
+	if _, err := api.Call(context.Background(), "GET", "/slow", nil); err != nil {
+		t.Errorf("Expected slots to be released, got %v", err)
+	}
+}
+
+func TestBulkheadHookWait(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/wait", func() { time.Sleep(10 * time.Millisecond) }, MustNewBulkheadHook(1, time.Second))
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := api.Call(context.Background(), "GET", "/wait", nil)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Expected waiting call to succeed, got %v", err)
+		}
+	}
+}
+
+func TestBulkheadHookInvalid(t *testing.T) {
+	for _, maxConcurrent := range []int{0, -1} {
+		if _, err := NewBulkheadHook(maxConcurrent, 0); err == nil {
+			t.Errorf("Expected an error for limit %d", maxConcurrent)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package dispatch
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
 )
@@ -9,6 +10,20 @@ import (
 type contextPathVars struct{}
 type contextLambdaRequest struct{}
 type contextLambdaResponse struct{}
+type contextHTTPRequest struct{}
+type contextHTTPResponseWriter struct{}
+type contextJWTClaims struct{}
+type contextAPIKeyOwner struct{}
+type contextRequestID struct{}
+type contextTraceID struct{}
+type contextTenant struct{}
+type contextUser struct{}
+type contextResponseContentType struct{}
+type contextPagination struct{}
+type contextSortParams struct{}
+type contextSearchQuery struct{}
+type contextFilters struct{}
+type contextAPIVersion struct{}
 
 func SetContextPathVars(ctx context.Context, pathVars PathVars) context.Context {
 	return context.WithValue(ctx, contextPathVars{}, pathVars)
@@ -45,3 +60,170 @@ func ContextLambdaResponse(ctx context.Context) *events.APIGatewayProxyResponse
 	}
 	return nil
 }
+
+func SetContextHTTPRequest(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, contextHTTPRequest{}, r)
+}
+
+func ContextHTTPRequest(ctx context.Context) *http.Request {
+	r, ok := ctx.Value(contextHTTPRequest{}).(*http.Request)
+	if ok {
+		return r
+	}
+	return nil
+}
+
+func SetContextHTTPResponseWriter(ctx context.Context, w http.ResponseWriter) context.Context {
+	return context.WithValue(ctx, contextHTTPResponseWriter{}, w)
+}
+
+func ContextHTTPResponseWriter(ctx context.Context) http.ResponseWriter {
+	w, ok := ctx.Value(contextHTTPResponseWriter{}).(http.ResponseWriter)
+	if ok {
+		return w
+	}
+	return nil
+}
+
+// JWTClaims holds the parsed payload of a verified JWT.
+type JWTClaims map[string]interface{}
+
+func SetContextJWTClaims(ctx context.Context, claims JWTClaims) context.Context {
+	return context.WithValue(ctx, contextJWTClaims{}, claims)
+}
+
+func ContextJWTClaims(ctx context.Context) JWTClaims {
+	claims, ok := ctx.Value(contextJWTClaims{}).(JWTClaims)
+	if ok {
+		return claims
+	}
+	return JWTClaims{}
+}
+
+func SetContextAPIKeyOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, contextAPIKeyOwner{}, owner)
+}
+
+func ContextAPIKeyOwner(ctx context.Context) string {
+	owner, ok := ctx.Value(contextAPIKeyOwner{}).(string)
+	if ok {
+		return owner
+	}
+	return ""
+}
+
+func SetContextRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextRequestID{}, id)
+}
+
+func ContextRequestID(ctx context.Context) string {
+	id, ok := ctx.Value(contextRequestID{}).(string)
+	if ok {
+		return id
+	}
+	return ""
+}
+
+func SetContextTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextTraceID{}, id)
+}
+
+func ContextTraceID(ctx context.Context) string {
+	id, ok := ctx.Value(contextTraceID{}).(string)
+	if ok {
+		return id
+	}
+	return ""
+}
+
+func SetContextTenant(ctx context.Context, tenant Tenant) context.Context {
+	return context.WithValue(ctx, contextTenant{}, tenant)
+}
+
+func ContextTenant(ctx context.Context) Tenant {
+	tenant, ok := ctx.Value(contextTenant{}).(Tenant)
+	if ok {
+		return tenant
+	}
+	return Tenant{}
+}
+
+func SetContextUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, contextUser{}, user)
+}
+
+func ContextUser(ctx context.Context) User {
+	return ctx.Value(contextUser{})
+}
+
+func SetContextResponseContentType(ctx context.Context, contentType string) context.Context {
+	return context.WithValue(ctx, contextResponseContentType{}, contentType)
+}
+
+func ContextResponseContentType(ctx context.Context) string {
+	contentType, ok := ctx.Value(contextResponseContentType{}).(string)
+	if ok {
+		return contentType
+	}
+	return ""
+}
+
+func SetContextPagination(ctx context.Context, pagination Pagination) context.Context {
+	return context.WithValue(ctx, contextPagination{}, pagination)
+}
+
+func ContextPagination(ctx context.Context) Pagination {
+	pagination, ok := ctx.Value(contextPagination{}).(Pagination)
+	if ok {
+		return pagination
+	}
+	return Pagination{}
+}
+
+func SetContextSortParams(ctx context.Context, sort SortParams) context.Context {
+	return context.WithValue(ctx, contextSortParams{}, sort)
+}
+
+func ContextSortParams(ctx context.Context) SortParams {
+	sort, ok := ctx.Value(contextSortParams{}).(SortParams)
+	if ok {
+		return sort
+	}
+	return SortParams{}
+}
+
+func SetContextSearchQuery(ctx context.Context, q string) context.Context {
+	return context.WithValue(ctx, contextSearchQuery{}, q)
+}
+
+func ContextSearchQuery(ctx context.Context) string {
+	q, ok := ctx.Value(contextSearchQuery{}).(string)
+	if ok {
+		return q
+	}
+	return ""
+}
+
+func SetContextFilters(ctx context.Context, filters map[string]interface{}) context.Context {
+	return context.WithValue(ctx, contextFilters{}, filters)
+}
+
+func ContextFilters(ctx context.Context) map[string]interface{} {
+	filters, ok := ctx.Value(contextFilters{}).(map[string]interface{})
+	if ok {
+		return filters
+	}
+	return make(map[string]interface{})
+}
+
+func SetContextAPIVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, contextAPIVersion{}, version)
+}
+
+func ContextAPIVersion(ctx context.Context) string {
+	version, ok := ctx.Value(contextAPIVersion{}).(string)
+	if ok {
+		return version
+	}
+	return ""
+}
@@ -0,0 +1,18 @@
+package dispatch
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextJWTClaims(t *testing.T) {
+	ctx := context.Background()
+	if claims := ContextJWTClaims(ctx); claims == nil || len(claims) != 0 {
+		t.Errorf("Expected empty claims, got %v", claims)
+	}
+
+	ctx = SetContextJWTClaims(ctx, JWTClaims{"sub": "user1"})
+	if sub := ContextJWTClaims(ctx)["sub"]; sub != "user1" {
+		t.Errorf("Expected sub user1, got %v", sub)
+	}
+}
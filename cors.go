@@ -0,0 +1,139 @@
+package dispatch
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CORSPolicy describes the cross-origin requests allowed for an endpoint.
+type CORSPolicy struct {
+	// AllowOrigins lists the origins allowed to make requests, or "*" for any
+	// origin. Requests from other origins get no Access-Control-Allow-Origin
+	// header.
+	AllowOrigins []string
+
+	// AllowMethods and AllowHeaders list the methods and request headers
+	// allowed in cross-origin requests.
+	AllowMethods []string
+	AllowHeaders []string
+
+	// ExposeHeaders lists the response headers that browsers may read.
+	ExposeHeaders []string
+
+	// AllowCredentials allows requests with credentials, such as cookies.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache the policy, if greater than zero.
+	MaxAge time.Duration
+}
+
+// headerDeleter is a headerSetter that can also remove headers.
+type headerDeleter interface {
+	headerSetter
+	Del(key string)
+}
+
+// apply replaces the default access control headers in header with those of
+// the policy, for a request from origin.
+func (p *CORSPolicy) apply(header headerDeleter, origin string) {
+	header.Del("Access-Control-Allow-Origin")
+	for _, allowed := range p.AllowOrigins {
+		if allowed == "*" {
+			header.Set("Access-Control-Allow-Origin", "*")
+			break
+		}
+		if allowed == origin {
+			header.Set("Access-Control-Allow-Origin", origin)
+			addVary(header, "Origin")
+			break
+		}
+	}
+	setOrDelete := func(key, value string) {
+		if value == "" {
+			header.Del(key)
+		} else {
+			header.Set(key, value)
+		}
+	}
+	setOrDelete("Access-Control-Allow-Methods", strings.Join(p.AllowMethods, ", "))
+	setOrDelete("Access-Control-Allow-Headers", strings.Join(p.AllowHeaders, ", "))
+	setOrDelete("Access-Control-Expose-Headers", strings.Join(p.ExposeHeaders, ", "))
+	header.Del("Access-Control-Allow-Credentials")
+	if p.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	header.Del("Access-Control-Max-Age")
+	if p.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+}
+
+// applyPreflight applies the policy to the response to an OPTIONS preflight
+// request from origin. If the policy lists no methods, the methods of the
+// endpoints at the request path are allowed, as without a policy.
+func (p *CORSPolicy) applyPreflight(header headerDeleter, origin string, methods []string) {
+	p.apply(header, origin)
+	if len(p.AllowMethods) == 0 {
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	}
+}
+
+// NewCORSHook returns a middleware hook that replaces the API's default access
+// control headers with those of policy, for the endpoints it is added to. The
+// headers are set when the hook runs, so they also apply to handlers that
+// write their own response, such as those registered with API.Handle or
+// streamed by NewStreamingJSONHook. Since the hook runs for each call, it can
+// be wrapped to choose the policy dynamically, such as from a configuration
+// store.
+//
+// OPTIONS preflight requests are answered by the proxies without running any
+// hooks. They use the policy of the last hook from NewCORSHook among the
+// global hooks and the hooks of the endpoint matching the request path and its
+// Access-Control-Request-Method header, including hooks combined with Chain.
+// Wrapped hooks are not found, so preflights for them use the defaults. Create
+// each hook once, when adding it, rather than for each call.
+func NewCORSHook(policy CORSPolicy) MiddlewareHook {
+	hook := func(input *EndpointInput) (*EndpointInput, error) {
+		if proxy := contextCallState(input.Ctx).proxyState(); proxy != nil {
+			proxy.setCORSPolicy(&policy)
+			// Apply the policy now for handlers that write the response themselves
+			w, r := ContextHTTPResponseWriter(input.Ctx), ContextHTTPRequest(input.Ctx)
+			if w != nil && r != nil {
+				policy.apply(w.Header(), r.Header.Get("Origin"))
+			}
+		}
+		return input, nil
+	}
+	registerHook(hook, &policy)
+	return hook
+}
+
+// preflightPolicy returns the policy that applies to a preflight request for
+// method at path, or nil if the defaults apply.
+func preflightPolicy(api *API, method, path string) *CORSPolicy {
+	hooks := api.GlobalHooks
+	if endpoint, _ := api.MatchEndpoint(method, path); endpoint != nil {
+		hooks = append(append([]MiddlewareHook{}, hooks...), endpoint.PreRequestHooks...)
+	}
+	var policy *CORSPolicy
+	for _, hook := range flattenHooks(hooks) {
+		if p, ok := lookupHook(hook).(*CORSPolicy); ok {
+			policy = p
+		}
+	}
+	return policy
+}
+
+// applyCORSPolicy applies the policy set with NewCORSHook during the call with
+// ctx, if any.
+func applyCORSPolicy(ctx context.Context, header headerDeleter, origin string) {
+	proxy := contextProxyState(ctx)
+	if proxy == nil {
+		return
+	}
+	if policy := proxy.corsPolicy(); policy != nil {
+		policy.apply(header, origin)
+	}
+}
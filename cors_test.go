@@ -0,0 +1,111 @@
+package dispatch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestCORSHook(t *testing.T) {
+	policy := CORSPolicy{
+		AllowOrigins:     []string{"https://app.example.com"},
+		AllowHeaders:     []string{"Content-Type", "X-CSRF-Token"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}
+	api := API{}
+	api.AddEndpoint("GET/private", func() {}, NewCORSHook(policy))
+	api.AddEndpoint("GET/public", func() {})
+
+	req := httptest.NewRequest("GET", "/private", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	header := rec.Header()
+	if header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		header.Get("Access-Control-Allow-Headers") != "Content-Type, X-CSRF-Token" ||
+		header.Get("Access-Control-Allow-Credentials") != "true" ||
+		header.Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("Unexpected headers %v", header)
+	}
+
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("Expected no allowed origin, got %q", origin)
+	}
+
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/public", nil))
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Expected default policy, got %q", origin)
+	}
+
+	res, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{
+		HTTPMethod: "GET",
+		Path:       "/private",
+		Headers:    map[string]string{"origin": "https://app.example.com"},
+	})
+	if origin := res.Headers["Access-Control-Allow-Origin"]; origin != "https://app.example.com" {
+		t.Errorf("Unexpected Lambda headers %v", res.Headers)
+	}
+}
+
+func TestCORSHookPreflight(t *testing.T) {
+	policy := CORSPolicy{
+		AllowOrigins:     []string{"https://app.example.com"},
+		AllowHeaders:     []string{"X-CSRF-Token"},
+		AllowCredentials: true,
+	}
+	api := API{}
+	api.AddEndpoint("PUT/private", func() {}, Chain(NewCORSHook(policy)))
+	api.AddEndpoint("GET/private", func() {})
+
+	req := httptest.NewRequest("OPTIONS", "/private", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	header := rec.Header()
+	if header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		header.Get("Access-Control-Allow-Headers") != "X-CSRF-Token" ||
+		header.Get("Access-Control-Allow-Credentials") != "true" ||
+		header.Get("Access-Control-Allow-Methods") != "PUT, GET" {
+		t.Errorf("Unexpected preflight headers %v", header)
+	}
+
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Expected default policy for GET preflight, got %q", origin)
+	}
+
+	res, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{
+		HTTPMethod: "OPTIONS",
+		Path:       "/private",
+		Headers:    map[string]string{"origin": "https://app.example.com", "access-control-request-method": "PUT"},
+	})
+	if res.Headers["Access-Control-Allow-Credentials"] != "true" || res.Headers["Access-Control-Allow-Origin"] != "https://app.example.com" {
+		t.Errorf("Unexpected Lambda preflight headers %v", res.Headers)
+	}
+}
+
+func TestCORSHookHandle(t *testing.T) {
+	api := API{}
+	api.Handle("GET/raw", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("raw"))
+	}), NewCORSHook(CORSPolicy{AllowOrigins: []string{"https://app.example.com"}}))
+
+	req := httptest.NewRequest("GET", "/raw", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+		t.Errorf("Expected the policy to apply before the handler writes, got %q", origin)
+	}
+}
@@ -0,0 +1,141 @@
+// Package dispatchtest provides utilities for testing APIs built with dispatch
+// over HTTP.
+package dispatchtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/flick-web/dispatch"
+)
+
+// NewHTTPClient returns an HTTP client for integration tests that sends every
+// request to baseURL, so that tests can use paths such as client.Get("/users")
+// without knowing where the API is served. If baseURL is empty, requests go to
+// a new httptest.Server serving api, which is shut down by the returned cleanup
+// function. NewHTTPClient panics if baseURL is not a valid URL.
+func NewHTTPClient(api *dispatch.API, baseURL string) (client *http.Client, cleanup func()) {
+	cleanup = func() {}
+	if baseURL == "" {
+		server := httptest.NewServer(api.Handler())
+		baseURL, cleanup = server.URL, server.Close
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		panic("dispatchtest: invalid base URL: " + err.Error())
+	}
+	client = &http.Client{
+		Transport: &baseURLTransport{base: base, next: http.DefaultTransport},
+	}
+	return client, cleanup
+}
+
+// baseURLTransport is an http.RoundTripper that sends requests to base.
+type baseURLTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t *baseURLTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.base.Scheme
+	r.URL.Host = t.base.Host
+	r.URL.Path = strings.TrimSuffix(t.base.Path, "/") + r.URL.Path
+	r.Host = ""
+	return t.next.RoundTrip(r)
+}
+
+// A TestServer serves an API over HTTP for the duration of a test, with
+// convenience methods for sending JSON requests to it.
+type TestServer struct {
+	*httptest.Server
+
+	t testing.TB
+}
+
+// NewTestServer starts a TestServer serving api, which is closed when the test
+// and its subtests complete.
+func NewTestServer(t testing.TB, api *dispatch.API) *TestServer {
+	server := httptest.NewServer(api.Handler())
+	t.Cleanup(server.Close)
+	return &TestServer{Server: server, t: t}
+}
+
+// Get sends a GET request for path.
+func (ts *TestServer) Get(path string) *http.Response {
+	ts.t.Helper()
+	return ts.Do("GET", path, nil)
+}
+
+// Post sends a POST request for path with body marshalled as JSON.
+func (ts *TestServer) Post(path string, body interface{}) *http.Response {
+	ts.t.Helper()
+	return ts.Do("POST", path, body)
+}
+
+// Put sends a PUT request for path with body marshalled as JSON.
+func (ts *TestServer) Put(path string, body interface{}) *http.Response {
+	ts.t.Helper()
+	return ts.Do("PUT", path, body)
+}
+
+// Patch sends a PATCH request for path with body marshalled as JSON.
+func (ts *TestServer) Patch(path string, body interface{}) *http.Response {
+	ts.t.Helper()
+	return ts.Do("PATCH", path, body)
+}
+
+// Delete sends a DELETE request for path.
+func (ts *TestServer) Delete(path string) *http.Response {
+	ts.t.Helper()
+	return ts.Do("DELETE", path, nil)
+}
+
+// Do sends a request for path with body marshalled as JSON, unless it is nil.
+// The response body is read in full, so it does not need to be closed. Any
+// error sending the request fails the test.
+func (ts *TestServer) Do(method, path string, body interface{}) *http.Response {
+	ts.t.Helper()
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatalf("marshalling %s %s body: %v", method, path, err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		ts.t.Fatalf("creating %s %s request: %v", method, path, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatalf("sending %s %s request: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatalf("reading %s %s response: %v", method, path, err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return resp
+}
+
+// DecodeJSON unmarshals the JSON body of a response from the server into v,
+// failing the test if it cannot.
+func (ts *TestServer) DecodeJSON(resp *http.Response, v interface{}) {
+	ts.t.Helper()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		ts.t.Fatalf("decoding response: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package dispatchtest
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/flick-web/dispatch"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	api := dispatch.API{}
+	api.AddEndpoint("GET/user/{foo}", func(ctx context.Context) string {
+		return dispatch.ContextPathVars(ctx)["foo"]
+	})
+
+	client, cleanup := NewHTTPClient(&api, "")
+	defer cleanup()
+
+	resp, err := client.Get("/user/abcde")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != `"abcde"` {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+}
+
+type doubleInput struct {
+	N int
+}
+
+func TestTestServer(t *testing.T) {
+	api := dispatch.API{}
+	api.AddEndpoint("POST/double", func(in doubleInput) int { return in.N * 2 })
+	api.AddEndpoint("DELETE/item", func() error { return dispatch.NewAPIError(418, "I'm a teapot") })
+
+	server := NewTestServer(t, &api)
+	resp := server.Post("/double", doubleInput{N: 5})
+	var out int
+	server.DecodeJSON(resp, &out)
+	if resp.StatusCode != 200 || out != 10 {
+		t.Errorf("Unexpected response %d %d", resp.StatusCode, out)
+	}
+
+	if resp := server.Delete("/item"); resp.StatusCode != 418 {
+		t.Errorf("Expected 418, got %d", resp.StatusCode)
+	}
+}
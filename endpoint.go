@@ -1,9 +1,17 @@
 package dispatch
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
 )
 
 // An Endpoint represents an API procedure.
@@ -18,6 +26,11 @@ type Endpoint struct {
 	// a Context struct value.
 	Path string
 
+	// Pattern is the path string that the endpoint was registered with, such as
+	// GET,POST/users/{id}. It is the same as Path unless several methods were
+	// registered at once, and is used to identify the endpoint in log messages.
+	Pattern string
+
 	// Handler must be a function that receives any single input variable, an
 	// input variable of type Context, neither, or both. It can return one
 	// output variable of any time, an error, neither, or both in the order
@@ -25,12 +38,34 @@ type Endpoint struct {
 	//
 	// The input value for Handler, if not Context, will automatically be
 	// unmarshalled from the input to API.Call.
+	//
+	// If Handler returns an *http.Response, the proxies send its status code,
+	// headers, and body directly to the client instead of marshalling it.
 	Handler interface{}
 
-	// PreRequestHook is a middleware hook that runs before the handler. If the
-	// hook returns an error, that error will be returned and the handler will
-	// not be called.
+	// PreRequestHooks are middleware hooks that run before the handler, in
+	// the order they were given to AddEndpoint, after all of the API's
+	// GlobalHooks. If a hook returns an error, that error will be returned and
+	// neither the remaining hooks nor the handler will be called.
 	PreRequestHooks []MiddlewareHook
+
+	// Deprecated marks the endpoint as deprecated. Responses from deprecated
+	// endpoints have a "Deprecation: true" header, and each call is logged as a
+	// warning.
+	Deprecated bool
+
+	// DeprecationDate, if set on a deprecated endpoint, is sent in the Sunset
+	// header as the time after which the endpoint may be removed.
+	DeprecationDate time.Time
+
+	// SunsetURL, if set on a deprecated endpoint, is sent in a Link header with
+	// relation type sunset, and should describe the deprecation.
+	SunsetURL string
+
+	// WarmUpFunc, if set, is called by API.WarmUp before the API serves
+	// traffic, to do slow initialization such as fetching keys or opening
+	// database connections.
+	WarmUpFunc func(context.Context) error
 }
 
 // EndpointInput represents the input to an endpoint call. These inputs can be
@@ -40,6 +75,22 @@ type EndpointInput struct {
 	Path   string
 	Ctx    context.Context
 	Input  json.RawMessage
+
+	// Headers holds the headers of the HTTP or Lambda request being handled.
+	// It is empty when API.Call is used directly.
+	Headers http.Header
+
+	// RemoteAddr is the IP address of the client, without a port. It is empty
+	// when API.Call is used directly. See API.TrustProxy for clients behind a
+	// proxy.
+	RemoteAddr string
+
+	// QueryParams holds the query string parameters of the HTTP or Lambda
+	// request being handled. It is empty when API.Call is used directly.
+	QueryParams url.Values
+
+	// Cookies holds the values of the cookies sent with the request, by name.
+	Cookies map[string]string
 }
 
 // MiddlewareHook is a function type that is called for each request.
@@ -52,15 +103,176 @@ type EndpointInput struct {
 // to return early if a call isn't authorized.
 type MiddlewareHook func(*EndpointInput) (*EndpointInput, error)
 
+// PostRequestHook is a function type that is called after an endpoint's
+// handler returns.
+//
+// The hook receives the endpoint input along with the output and error of the
+// rest of the call, and returns the output and error to use in their place. It
+// also runs when a later middleware hook fails, in which case out is nil. Post
+// request hooks are added to an endpoint with After, and run in the reverse of
+// the order they were added, like deferred functions.
+type PostRequestHook func(input *EndpointInput, out interface{}, err error) (interface{}, error)
+
+// After returns a middleware hook that runs hook once the rest of the call has
+// completed. The returned hook can be used anywhere a MiddlewareHook can.
+func After(hook PostRequestHook) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			out, err := next()
+			return hook(input, out, err)
+		})
+		return input, nil
+	}
+}
+
+// Chain returns a middleware hook that runs hooks in order, stopping at the
+// first one that returns an error, so that a common set of hooks can be added
+// to endpoints as one. API.MiddlewareOrder lists the hooks in the chain rather
+// than the chain itself. If a hook in the chain panics, the call wrappers added
+// by earlier hooks in the chain, such as the one that releases the lock taken
+// by NewMutexHook, run around the panic before it continues, as they would if
+// the hooks had been added separately.
+func Chain(hooks ...MiddlewareHook) MiddlewareHook {
+	chain := &namedHook{hooks: hooks}
+	var names []string
+	for _, hook := range hooks {
+		names = append(names, hookNames(hook)...)
+	}
+	chain.Name = strings.Join(names, "+")
+	hook := func(input *EndpointInput) (*EndpointInput, error) {
+		state := contextCallState(input.Ctx)
+		if state != nil {
+			defer func() {
+				if r := recover(); r != nil {
+					state.wrapNext(func() (interface{}, error) { panic(r) })()
+				}
+			}()
+		}
+		for _, hook := range chain.hooks {
+			if state != nil {
+				if err := state.aborted(); err != nil {
+					return nil, err
+				}
+			}
+			var err error
+			input, err = hook(input)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return input, nil
+	}
+	registerHook(hook, chain)
+	return hook
+}
+
+// A namedHook describes the hooks combined by Chain, with a name made of the
+// names of its hooks joined by "+".
+type namedHook struct {
+	Name  string
+	hooks []MiddlewareHook
+}
+
+// hookNames returns the names of the hooks that hook runs: the names of its
+// constituent hooks if it was made by Chain, or otherwise its own name.
+func hookNames(hook MiddlewareHook) []string {
+	chain, ok := lookupHook(hook).(*namedHook)
+	if !ok {
+		return []string{funcName(hook)}
+	}
+	if chain.Name == "" {
+		return nil
+	}
+	return strings.Split(chain.Name, "+")
+}
+
+// flattenHooks returns hooks with each hook made by Chain replaced by the
+// hooks it runs, in order.
+func flattenHooks(hooks []MiddlewareHook) []MiddlewareHook {
+	var flat []MiddlewareHook
+	for _, hook := range hooks {
+		if chain, ok := lookupHook(hook).(*namedHook); ok {
+			flat = append(flat, flattenHooks(chain.hooks)...)
+		} else {
+			flat = append(flat, hook)
+		}
+	}
+	return flat
+}
+
+// hookDetails records what the functions that make hooks, such as Chain and
+// NewCORSHook, know about them, so that the hooks can be inspected without
+// running them.
+var hookDetails sync.Map
+
+// hookKey identifies a hook value. Closures made by the same function share
+// their code pointer, so the address of the closure itself is used. Keeping it
+// as a pointer in hookDetails stops the address from being reused.
+func hookKey(hook MiddlewareHook) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&hook))
+}
+
+// registerHook records details about a hook, for lookupHook.
+func registerHook(hook MiddlewareHook, details interface{}) {
+	hookDetails.Store(hookKey(hook), details)
+}
+
+// lookupHook returns the details recorded for a hook with registerHook, or nil.
+func lookupHook(hook MiddlewareHook) interface{} {
+	details, _ := hookDetails.Load(hookKey(hook))
+	return details
+}
+
 // AddEndpoint registers an endpoint with this API. It also allows adding
 // middleware hooks to the endpoint.
-func (api *API) AddEndpoint(path string, handler interface{}, hooks ...MiddlewareHook) {
-	if api.Endpoints == nil {
-		api.Endpoints = make([]*Endpoint, 0)
+//
+// The path may list several comma-separated methods, as in GET,POST/resource,
+// to register the same handler and hooks for each of them.
+//
+// AddEndpoint returns an error if the path is malformed, such as one with a
+// missing method, an empty segment, or an unclosed path variable brace, or if
+// the API has been frozen with Freeze.
+func (api *API) AddEndpoint(path string, handler interface{}, hooks ...MiddlewareHook) error {
+	if api.frozen {
+		return ErrFrozen
+	}
+	if err := validatePattern(path); err != nil {
+		return err
+	}
+	i := strings.Index(path, "/")
+	methods, rest := path[:i], path[i:]
+	endpoints := make([]*Endpoint, 0)
+	for _, method := range strings.Split(methods, ",") {
+		endpoint, err := newEndpoint(strings.TrimSpace(method)+rest, path, handler, hooks)
+		if err != nil {
+			return err
+		}
+		endpoints = append(endpoints, endpoint)
 	}
+	api.Endpoints = append(api.Endpoints, endpoints...)
+	return nil
+}
+
+// MustAddEndpoint is like AddEndpoint, but panics if the endpoint cannot be
+// added.
+func (api *API) MustAddEndpoint(path string, handler interface{}, hooks ...MiddlewareHook) {
+	if err := api.AddEndpoint(path, handler, hooks...); err != nil {
+		panic(fmt.Sprintf("dispatch: adding endpoint %s: %v", path, err))
+	}
+}
+
+// Freeze prevents any further endpoints from being added to the API. Freezing
+// the API once it starts serving requests catches endpoints that are
+// accidentally registered late.
+func (api *API) Freeze() {
+	api.frozen = true
+}
 
-	endpoint := Endpoint{
+// newEndpoint creates an endpoint for a path with a single method.
+func newEndpoint(path, pattern string, handler interface{}, hooks []MiddlewareHook) (*Endpoint, error) {
+	endpoint := &Endpoint{
 		Path:    path,
+		Pattern: pattern,
 		Handler: handler,
 	}
 	// Configure middleware hooks
@@ -71,7 +283,127 @@ func (api *API) AddEndpoint(path string, handler interface{}, hooks ...Middlewar
 	var err error
 	endpoint.pathMatcher, err = NewAPIPath(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// responseWritten is returned by handlers that have already written their
+// response directly, so that the proxy does not write anything further.
+type responseWritten struct{}
+
+// Handle registers an http.Handler as an endpoint. Dispatch does not marshal
+// any output for it. The handler is served a request rebuilt from the
+// EndpointInput left by the endpoint's hooks, so changes they make to its
+// method, path, headers, input, or context are visible to it. The hook that
+// passes the input on is listed last by MiddlewareOrder. When called
+// through HTTPProxy, the handler writes to the original response writer.
+// Elsewhere, such as through LambdaProxy, its response is recorded and
+// returned as an *http.Response.
+func (api *API) Handle(path string, h http.Handler, hooks ...MiddlewareHook) error {
+	handler := func(ctx context.Context) (interface{}, error) {
+		in, _ := ctx.Value(contextHandlerInput{}).(*EndpointInput)
+		if in == nil {
+			return nil, ErrInternal
+		}
+		r, err := in.httpRequest()
+		if err != nil {
+			return nil, err
+		}
+		if w := ContextHTTPResponseWriter(ctx); w != nil {
+			h.ServeHTTP(w, r)
+			return responseWritten{}, nil
+		}
+		recorder := newResponseRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder.result(), nil
+	}
+	hooks = append(hooks[:len(hooks):len(hooks)], handlerInputHook)
+	return api.AddEndpoint(path, handler, hooks...)
+}
+
+type contextHandlerInput struct{}
+
+// handlerInputHook runs after the other hooks of an endpoint registered with
+// Handle, and passes their final input to the handler.
+func handlerInputHook(input *EndpointInput) (*EndpointInput, error) {
+	input.Ctx = context.WithValue(input.Ctx, contextHandlerInput{}, input)
+	return input, nil
+}
+
+// httpRequest builds an HTTP request from the input, starting from the HTTP or
+// Lambda request being handled, if any, for the fields the input does not
+// hold, such as the query string and remote address.
+func (in *EndpointInput) httpRequest() (*http.Request, error) {
+	r := ContextHTTPRequest(in.Ctx)
+	if r != nil {
+		r = r.Clone(in.Ctx)
+	} else if apr := ContextLambdaRequest(in.Ctx); apr != nil {
+		var err error
+		if r, err = lambdaHTTPRequest(in.Ctx, apr); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if r, err = http.NewRequestWithContext(in.Ctx, in.Method, in.Path, nil); err != nil {
+			return nil, err
+		}
+	}
+	r.Method = in.Method
+	r.URL.Path, r.URL.RawPath = in.Path, ""
+	if in.Headers != nil {
+		r.Header = in.Headers.Clone()
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(in.Input))
+	r.ContentLength = int64(len(in.Input))
+	return r, nil
+}
+
+// responseRecorder is an http.ResponseWriter that records the response of a
+// handler registered with Handle, so that it can be returned as an
+// *http.Response.
+type responseRecorder struct {
+	header  http.Header
+	written http.Header
+	status  int
+	body    bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header)}
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.written = rec.header.Clone()
+	}
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		if rec.header.Get("Content-Type") == "" {
+			rec.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		rec.WriteHeader(http.StatusOK)
+	}
+	return rec.body.Write(p)
+}
+
+func (rec *responseRecorder) result() *http.Response {
+	rec.WriteHeader(http.StatusOK)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.written,
+		Body:          ioutil.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
 	}
-	api.Endpoints = append(api.Endpoints, &endpoint)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"log"
+	"net/http"
 	"strings"
 	"testing"
 )
@@ -121,3 +122,264 @@ func middlewareHook(input *EndpointInput) (*EndpointInput, error) {
 func testAPIErrors() *APIError {
 	return NewAPIError(418, "I'm a teapot")
 }
+
+func TestEndpointMultipleMethods(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET,POST/test/{foo}", testPathVarHandler)
+
+	for _, endpoint := range api.Endpoints {
+		if endpoint.Pattern != "GET,POST/test/{foo}" {
+			t.Errorf("Unexpected pattern %s for %s", endpoint.Pattern, endpoint.Path)
+		}
+	}
+
+	methods := api.GetMethodsForPath("/test/x")
+	if strings.Join(methods, ", ") != "GET, POST" {
+		t.Errorf("Expected GET, POST, got %s", strings.Join(methods, ", "))
+	}
+
+	ctx := context.Background()
+	for _, method := range []string{"GET", "POST"} {
+		result, err := api.Call(ctx, method, "/test/abcde", []byte("{}"))
+		if result != "abcde" || err != nil {
+			t.Errorf("%s: %v, %v", method, result, err)
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	handlerCalled := false
+	abortHook := func(input *EndpointInput) (*EndpointInput, error) {
+		done := make(chan struct{})
+		go func() {
+			Abort(input.Ctx, NewAPIErrorFromStatus(http.StatusForbidden))
+			close(done)
+		}()
+		<-done
+		return input, nil
+	}
+	laterHook := func(input *EndpointInput) (*EndpointInput, error) {
+		t.Error("Hook after Abort should not run")
+		return input, nil
+	}
+
+	api := API{}
+	api.AddEndpoint("GET/test", func() { handlerCalled = true }, abortHook, laterHook)
+
+	_, err := api.Call(context.Background(), "GET", "/test", nil)
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403, got %v", err)
+	}
+	if handlerCalled {
+		t.Error("Handler should not have been called")
+	}
+}
+
+func TestPostRequestHooks(t *testing.T) {
+	var order []string
+	postHook := func(name string) PostRequestHook {
+		return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+			order = append(order, name)
+			if err != nil {
+				return nil, err
+			}
+			return out.(string) + name, nil
+		}
+	}
+	failHook := func(input *EndpointInput) (*EndpointInput, error) {
+		return nil, errors.New("ERROR")
+	}
+
+	api := API{}
+	api.AddEndpoint("GET/test", func() string { return "out" }, After(postHook("1")), After(postHook("2")))
+	api.AddEndpoint("GET/fail", func() string { return "out" }, After(postHook("1")), failHook, After(postHook("2")))
+
+	out, err := api.Call(context.Background(), "GET", "/test", nil)
+	if out != "out21" || err != nil {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+	if strings.Join(order, ",") != "2,1" {
+		t.Errorf("Expected post hooks to run in reverse order, got %v", order)
+	}
+
+	order = nil
+	_, err = api.Call(context.Background(), "GET", "/fail", nil)
+	if err == nil || err.Error() != "ERROR" {
+		t.Error(err)
+	}
+	if strings.Join(order, ",") != "1" {
+		t.Errorf("Expected only the first post hook to run, got %v", order)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	api := API{}
+	if err := api.AddEndpoint("GET/before", testEndpointHandler); err != nil {
+		t.Error(err)
+	}
+	api.Freeze()
+	if err := api.AddEndpoint("GET/after", testEndpointHandler); err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+	if len(api.Endpoints) != 1 {
+		t.Errorf("Expected 1 endpoint, got %d", len(api.Endpoints))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MustAddEndpoint should have panicked")
+		}
+	}()
+	api.MustAddEndpoint("GET/after", testEndpointHandler)
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	api := API{}
+	api.GlobalHooks = []MiddlewareHook{LogHook(log.Default())}
+	api.AddEndpoint("GET/test/{TestVar}", testEndpointHandler, middlewareHook, NewHMACHook("secret", "X-Signature"))
+
+	expected := "dispatch.LogHook.func1, dispatch.middlewareHook, dispatch.NewHMACHook.func1"
+	if got := strings.Join(api.MiddlewareOrder("GET", "/test/x"), ", "); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if got := strings.Join(api.MiddlewareOrder("GET", "/none"), ", "); got != "dispatch.LogHook.func1" {
+		t.Errorf("Expected only global hooks, got %s", got)
+	}
+}
+
+func TestGlobalHooksRouting(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("PUT/test/{foo}", func(ctx context.Context) string {
+		return ContextPathVars(ctx)["foo"]
+	})
+	api.GlobalHooks = []MiddlewareHook{func(input *EndpointInput) (*EndpointInput, error) {
+		input.Method = "PUT"
+		return input, nil
+	}}
+
+	out, err := api.Call(context.Background(), "POST", "/test/abc", nil)
+	if out != "abc" || err != nil {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+}
+
+func TestHookOrder(t *testing.T) {
+	var order []string
+	recordHook := func(name string) MiddlewareHook {
+		return func(input *EndpointInput) (*EndpointInput, error) {
+			order = append(order, name)
+			return input, nil
+		}
+	}
+
+	api := API{}
+	api.GlobalHooks = []MiddlewareHook{recordHook("global1"), recordHook("global2")}
+	api.AddEndpoint("GET/test", func() {}, recordHook("endpoint1"), recordHook("endpoint2"))
+	api.GlobalHooks = append(api.GlobalHooks, recordHook("global3"))
+
+	for i := 0; i < 3; i++ {
+		order = nil
+		if _, err := api.Call(context.Background(), "GET", "/test", nil); err != nil {
+			t.Fatal(err)
+		}
+		expected := "global1, global2, global3, endpoint1, endpoint2"
+		if got := strings.Join(order, ", "); got != expected {
+			t.Errorf("Expected hook order %s, got %s", expected, got)
+		}
+	}
+}
+
+func TestAddEndpointValidation(t *testing.T) {
+	api := API{}
+	invalid := []string{
+		"",
+		"GET",
+		"GET/",
+		"/GET/users",
+		"get/users",
+		"GET,/users",
+		"PATCH/{unclosed",
+		"PATCH/unopened}",
+		"GET/users/{}",
+		"GET/users//posts",
+		"GET/users/",
+		"GET/items/{id:bogus}",
+	}
+	for _, pattern := range invalid {
+		if err := api.AddEndpoint(pattern, testEndpointHandler); err == nil {
+			t.Errorf("Expected error for pattern %q", pattern)
+		}
+	}
+	if len(api.Endpoints) != 0 {
+		t.Errorf("Expected no endpoints, got %d", len(api.Endpoints))
+	}
+
+	for _, pattern := range []string{"GET/users", "GET,POST/users/{id:int}/posts/{post}"} {
+		if err := api.AddEndpoint(pattern, testEndpointHandler); err != nil {
+			t.Errorf("Pattern %q: %v", pattern, err)
+		}
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/a", testEndpointHandler)
+	api.AddEndpoint("GET/b", testEndpointHandler)
+	api.AddEndpoint("GET/c", testEndpointHandler)
+
+	var warmed []string
+	api.Endpoints[0].WarmUpFunc = func(ctx context.Context) error {
+		warmed = append(warmed, "a")
+		return nil
+	}
+	api.Endpoints[2].WarmUpFunc = func(ctx context.Context) error {
+		warmed = append(warmed, "c")
+		return nil
+	}
+	if err := api.WarmUp(context.Background()); err != nil || len(warmed) != 2 {
+		t.Errorf("WarmUp returned %v after warming %v", err, warmed)
+	}
+
+	api.Endpoints[0].WarmUpFunc = func(ctx context.Context) error { return errTemporary }
+	warmed = nil
+	if err := api.WarmUp(context.Background()); err != errTemporary || len(warmed) != 0 {
+		t.Errorf("Expected WarmUp to stop at the first error, got %v after warming %v", err, warmed)
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string, err error) MiddlewareHook {
+		return func(input *EndpointInput) (*EndpointInput, error) {
+			order = append(order, name)
+			return input, err
+		}
+	}
+	api := API{}
+	api.AddEndpoint("GET/ok", func() {}, Chain(record("a", nil), Chain(record("b", nil), record("c", nil))))
+	api.AddEndpoint("GET/fail", func() {}, Chain(record("a", errTemporary), record("b", nil)))
+
+	if _, err := api.Call(context.Background(), "GET", "/ok", nil); err != nil || strings.Join(order, "") != "abc" {
+		t.Errorf("Unexpected result %v with order %v", err, order)
+	}
+	order = nil
+	if _, err := api.Call(context.Background(), "GET", "/fail", nil); err != errTemporary || strings.Join(order, "") != "a" {
+		t.Errorf("Expected chain to stop at first error, got %v with order %v", err, order)
+	}
+}
+
+func TestChainMiddlewareOrder(t *testing.T) {
+	api := API{}
+	chain := Chain(middlewareHook, Chain(LogHook(log.Default()), NewHMACHook("secret", "X-Signature")))
+	api.AddEndpoint("GET/test/{TestVar}", testEndpointHandler, chain)
+
+	expected := "dispatch.middlewareHook, dispatch.LogHook.func1, dispatch.NewHMACHook.func1"
+	if got := strings.Join(api.MiddlewareOrder("GET", "/test/x"), ", "); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	api.AddEndpoint("GET/empty", testEndpointHandler, Chain(middlewareHook, Chain()))
+	if got := strings.Join(api.MiddlewareOrder("GET", "/empty"), ", "); got != "dispatch.middlewareHook" {
+		t.Errorf("Expected empty chains to be left out, got %s", got)
+	}
+}
@@ -39,3 +39,23 @@ var ErrNotFound = errors.New("path not found")
 
 // ErrInternal represents some unexpected internal error.
 var ErrInternal = errors.New("internal error")
+
+// ErrFrozen is returned when adding an endpoint to an API after Freeze.
+var ErrFrozen = errors.New("api is frozen")
+
+// ErrorStatusCode returns the HTTP status code to respond with for err. An
+// *APIError uses its own status code, ErrNotFound and ErrBadRequest map to 404
+// and 400, and every other error is a 500.
+func ErrorStatusCode(err error) int {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.StatusCode
+	}
+	switch err {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrBadRequest:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
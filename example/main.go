@@ -9,13 +9,24 @@ import (
 	"github.com/flick-web/dispatch"
 )
 
+type greeting struct {
+	Greeting string `json:"greeting"`
+}
+
 func rootHandler(ctx context.Context) string {
 	return fmt.Sprintf("Hello, %s!", dispatch.ContextPathVars(ctx)["name"])
 }
 
+// greetHandler is registered with dispatch.WrapHandler, so its signature is
+// checked at compile time.
+func greetHandler(ctx context.Context, in greeting) (string, error) {
+	return fmt.Sprintf("%s, %s!", in.Greeting, dispatch.ContextPathVars(ctx)["name"]), nil
+}
+
 func main() {
 	api := &dispatch.API{}
 	api.AddEndpoint("GET/{name}", rootHandler)
+	api.AddEndpoint("POST/{name}", dispatch.WrapHandler(greetHandler))
 	http.HandleFunc("/", api.HTTPProxy)
 	log.Fatal(http.ListenAndServe(":8000", nil))
 }
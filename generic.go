@@ -0,0 +1,68 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// WrapHandler converts a typed handler function into a handler for
+// API.AddEndpoint. Since the signature of fn is checked by the compiler, a
+// handler with the wrong number or order of arguments is caught at build time
+// instead of when it is first called.
+//
+// The returned handler unmarshals the JSON input into a T and passes it to fn,
+// as API.Call does for any handler that takes an input value.
+func WrapHandler[T any, R any](fn func(context.Context, T) (R, error)) interface{} {
+	return func(ctx context.Context, input json.RawMessage) (R, error) {
+		var in T
+		if err := json.Unmarshal(input, &in); err != nil {
+			var out R
+			return out, err
+		}
+		return fn(ctx, in)
+	}
+}
+
+// A TypedEndpoint is an endpoint whose handler has typed input and output
+// values, for compile-time checking of handlers and their callers.
+type TypedEndpoint[TIn, TOut any] struct {
+	Handler func(context.Context, TIn) (TOut, error)
+
+	api *API
+}
+
+// Register adds the endpoint to api at the given path, as with
+// API.AddEndpoint.
+func (te *TypedEndpoint[TIn, TOut]) Register(api *API, path string, hooks ...MiddlewareHook) error {
+	if err := api.AddEndpoint(path, WrapHandler(te.Handler), hooks...); err != nil {
+		return err
+	}
+	te.api = api
+	return nil
+}
+
+// Call marshals input and sends it through the API that the endpoint was
+// registered with, as with API.Call, and returns the typed output. It is
+// intended for unit tests, which can check the output without type
+// assertions.
+func (te *TypedEndpoint[TIn, TOut]) Call(ctx context.Context, method, path string, input TIn) (TOut, error) {
+	var out TOut
+	if te.api == nil {
+		return out, errors.New("endpoint is not registered")
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return out, err
+	}
+	result, err := te.api.Call(ctx, method, path, data)
+	if err != nil || result == nil {
+		return out, err
+	}
+	out, ok := result.(TOut)
+	if !ok {
+		return out, fmt.Errorf("unexpected output type %T", result)
+	}
+	return out, nil
+}
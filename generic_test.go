@@ -0,0 +1,40 @@
+package dispatch
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func testTypedHandler(ctx context.Context, in testInputType) (int, error) {
+	return in.Var2 * 2, nil
+}
+
+func TestWrapHandler(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/double", WrapHandler(testTypedHandler))
+
+	out, err := api.Call(context.Background(), "POST", "/double", []byte(`{"Var2": 21}`))
+	if out != 42 || err != nil {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+
+	_, err = api.Call(context.Background(), "POST", "/double", []byte(`{"Var2": "x"}`))
+	if err == nil || !strings.Contains(err.Error(), "cannot unmarshal") {
+		t.Errorf("Expected unmarshal error, got %v", err)
+	}
+}
+
+func TestTypedEndpoint(t *testing.T) {
+	endpoint := &TypedEndpoint[testInputType, int]{Handler: testTypedHandler}
+	if _, err := endpoint.Call(context.Background(), "POST", "/double", testInputType{}); err == nil {
+		t.Error("Expected error for unregistered endpoint")
+	}
+
+	api := API{}
+	endpoint.Register(&api, "POST/double")
+	out, err := endpoint.Call(context.Background(), "POST", "/double", testInputType{Var2: 4})
+	if out != 8 || err != nil {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+}
@@ -1,5 +1,17 @@
 module github.com/flick-web/dispatch
 
-go 1.14
+go 1.18
 
-require github.com/aws/aws-lambda-go v1.27.0
+require (
+	github.com/aws/aws-lambda-go v1.27.0
+	github.com/google/uuid v1.6.0
+	github.com/nyaruka/phonenumbers v1.2.2
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/text v0.3.8
+)
+
+require (
+	github.com/xeipuuv/gojsonpointer v0.0.0-20180127040702-4e3ac2762d5f // indirect
+	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
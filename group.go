@@ -0,0 +1,48 @@
+package dispatch
+
+import "strings"
+
+// An EndpointGroup registers endpoints that share a path prefix and a set of
+// middleware hooks.
+type EndpointGroup struct {
+	api    *API
+	prefix string
+	hooks  []MiddlewareHook
+}
+
+// Group returns an EndpointGroup that registers endpoints on this API under
+// prefix. The given hooks run before the hooks of each endpoint in the group.
+func (api *API) Group(prefix string, hooks ...MiddlewareHook) *EndpointGroup {
+	return &EndpointGroup{
+		api:    api,
+		prefix: strings.Trim(prefix, "/"),
+		hooks:  hooks,
+	}
+}
+
+// Use adds shared middleware hooks to the group. Hooks added with Use run
+// before any hooks already on the group, and only apply to endpoints that are
+// added after the call. It returns the group for chaining.
+func (g *EndpointGroup) Use(hooks ...MiddlewareHook) *EndpointGroup {
+	g.hooks = append(append([]MiddlewareHook{}, hooks...), g.hooks...)
+	return g
+}
+
+// AddEndpoint registers an endpoint with the group's API. The path has the same
+// format as for API.AddEndpoint, and the group's prefix is inserted after the
+// method, so GET/users in a group with prefix v1 is registered as GET/v1/users.
+func (g *EndpointGroup) AddEndpoint(path string, handler interface{}, hooks ...MiddlewareHook) error {
+	method, rest := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		method, rest = path[:i], path[i+1:]
+	}
+	fullPath := method + "/" + g.prefix
+	if rest != "" {
+		fullPath += "/" + rest
+	}
+
+	groupHooks := make([]MiddlewareHook, 0, len(g.hooks)+len(hooks))
+	groupHooks = append(groupHooks, g.hooks...)
+	groupHooks = append(groupHooks, hooks...)
+	return g.api.AddEndpoint(fullPath, handler, groupHooks...)
+}
@@ -0,0 +1,33 @@
+package dispatch
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	var order []string
+	recordHook := func(name string) MiddlewareHook {
+		return func(input *EndpointInput) (*EndpointInput, error) {
+			order = append(order, name)
+			return input, nil
+		}
+	}
+
+	api := API{}
+	group := api.Group("/v1/", recordHook("group"))
+	group.Use(recordHook("use1"), recordHook("use2")).Use(recordHook("use0"))
+	group.AddEndpoint("GET/user/{foo}", testPathVarHandler, recordHook("endpoint"))
+
+	result, err := api.Call(context.Background(), "GET", "/v1/user/abcde", []byte("{}"))
+	if result != "abcde" || err != nil {
+		t.Error(result)
+		t.Error(err)
+	}
+
+	expected := "use0, use1, use2, group, endpoint"
+	if got := strings.Join(order, ", "); got != expected {
+		t.Errorf("Expected hook order %s, got %s", expected, got)
+	}
+}
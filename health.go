@@ -0,0 +1,64 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// A HealthCheck is a named check of a dependency, such as a database
+// connection. Check returns an error if the dependency is unhealthy.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// healthResponse is the body written by HealthHandler.
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// HealthHandler returns an http.Handler that runs the given checks
+// concurrently and reports their results as JSON, with status 200 if every
+// check passes and 503 otherwise. For example:
+//
+//	{"status": "unavailable", "checks": {"db": "ok", "cache": "connection refused"}}
+//
+// The handler is separate from any API, so it can be mounted on a path that
+// bypasses dispatch routing, middleware hooks, and CORS handling entirely.
+func HealthHandler(checks ...HealthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := healthResponse{
+			Status: "ok",
+			Checks: make(map[string]string, len(checks)),
+		}
+		status := http.StatusOK
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, check := range checks {
+			wg.Add(1)
+			go func(check HealthCheck) {
+				defer wg.Done()
+				result := "ok"
+				if err := check.Check(r.Context()); err != nil {
+					result = err.Error()
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				response.Checks[check.Name] = result
+				if result != "ok" {
+					response.Status = "unavailable"
+					status = http.StatusServiceUnavailable
+				}
+			}(check)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	})
+}
@@ -0,0 +1,27 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ok := HealthCheck{"db", func(ctx context.Context) error { return nil }}
+	failing := HealthCheck{"cache", func(ctx context.Context) error { return errors.New("connection refused") }}
+
+	rec := httptest.NewRecorder()
+	HealthHandler(ok).ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"ok"`) {
+		t.Errorf("Unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	HealthHandler(ok, failing).ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"cache":"connection refused"`) {
+		t.Errorf("Unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,546 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// logBodyLimit is the number of body bytes logged by LogHook.
+const logBodyLimit = 512
+
+// LogHook returns a middleware hook that logs each request as it starts, with
+// its method, path, request ID, path variables, and the first 512 bytes of its
+// body. Unlike an access log, which is written once the response is known, this
+// is meant for debugging and audit trails.
+func LogHook(logger Logger) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		body := input.Input
+		truncated := ""
+		if len(body) > logBodyLimit {
+			body = body[:logBodyLimit]
+			truncated = "..."
+		}
+		logger.Printf("%s %s request_id=%s path_vars=%v body=%q%s\n",
+			input.Method, input.Path, ContextRequestID(input.Ctx), ContextPathVars(input.Ctx), body, truncated)
+		return input, nil
+	}
+}
+
+// MetricsHook returns a middleware hook that reports each call to counter once
+// it completes, with the request method, the matched endpoint's path pattern
+// without its method, such as /users/{id}, the response status code, and the
+// time taken by the rest of the call. It can be used to feed Prometheus,
+// Datadog, CloudWatch, or any other metrics system.
+func MetricsHook(counter func(method, path string, status int, latency time.Duration)) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		start := time.Now()
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			out, err := next()
+			counter(input.Method, endpointPattern(input.Ctx), responseStatus(out, err), time.Since(start))
+			return out, err
+		})
+		return input, nil
+	}
+}
+
+// NewRetryHook returns a middleware hook that retries the rest of the call,
+// including the handler and any hooks after this one, when it fails with an
+// error for which retryOn returns true. The call is attempted at most
+// maxAttempts times, waiting backoff between attempts, and the last result is
+// returned. Each attempt starts from the input as it was when this hook ran,
+// so changes made by later hooks, such as a cancelled timeout context or a
+// transformed body, are not carried over to the next attempt.
+//
+// Since the handler is run again in full, only use this hook with idempotent
+// handlers, or with handlers that deduplicate requests by an idempotency key.
+func NewRetryHook(maxAttempts int, backoff time.Duration, retryOn func(error) bool) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			saved := *input
+			saved.Headers = input.Headers.Clone()
+			out, err := next()
+			for attempt := 1; attempt < maxAttempts && err != nil && retryOn(err); attempt++ {
+				*input = saved
+				input.Headers = saved.Headers.Clone()
+				select {
+				case <-input.Ctx.Done():
+					return out, err
+				case <-time.After(backoff):
+				}
+				out, err = next()
+			}
+			return out, err
+		})
+		return input, nil
+	}
+}
+
+// NewTraceHook returns a middleware hook that reads a distributed trace ID from
+// the traceHeader request header, such as X-B3-TraceId or traceparent, and
+// stores it in the context. Handlers can read it with ContextTraceID to forward
+// it in their own outgoing requests. If traceHeader is empty, traceparent is
+// used.
+func NewTraceHook(traceHeader string) MiddlewareHook {
+	if traceHeader == "" {
+		traceHeader = "traceparent"
+	}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if id := input.Headers.Get(traceHeader); id != "" {
+			input.Ctx = SetContextTraceID(input.Ctx, id)
+		}
+		return input, nil
+	}
+}
+
+// NewCacheControlHook returns a middleware hook that sets the Cache-Control
+// response header to directive, such as "public, max-age=60", when the call
+// succeeds. Error responses are sent without it.
+func NewCacheControlHook(directive string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			out, err := next()
+			if err == nil {
+				SetResponseHeader(input.Ctx, "Cache-Control", directive)
+			}
+			return out, err
+		})
+		return input, nil
+	}
+}
+
+// NewRequestSizeHook returns a middleware hook that rejects requests with a
+// body larger than maxBytes with status 413. It can be used on endpoints that
+// need a stricter limit than the rest of the API.
+func NewRequestSizeHook(maxBytes int64) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if int64(len(input.Input)) > maxBytes {
+			return nil, NewAPIError(http.StatusRequestEntityTooLarge, "request too large")
+		}
+		return input, nil
+	}
+}
+
+// NewResponseSizeHook returns a post-request hook that logs a warning to logger
+// when the JSON encoding of a successful call's output is larger than
+// maxBytes, such as to find responses that approach Lambda's 6 MB limit. If
+// logger is nil, the API's Logger is used. If API.TruncateLargeResponses is
+// set, such responses are also replaced with an error with status 500. Upstream
+// *http.Response outputs are not checked. Add the hook to an endpoint with
+// After.
+func NewResponseSizeHook(maxBytes int64, logger Logger) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return out, err
+		}
+		data := out
+		switch resp := out.(type) {
+		case *http.Response, responseWritten:
+			return out, err
+		case *Response:
+			if resp == nil || resp.Body == nil {
+				return out, err
+			}
+			data = resp.Body
+		}
+		encoded, marshalErr := json.Marshal(data)
+		if marshalErr != nil || int64(len(encoded)) <= maxBytes {
+			return out, err
+		}
+		api := contextAPI(input.Ctx)
+		warnLogger := logger
+		if warnLogger == nil {
+			warnLogger = api.logger()
+		}
+		warnLogger.Printf("Response to %s %s is %d bytes, over the limit of %d\n", input.Method, input.Path, len(encoded), maxBytes)
+		if api != nil && api.TruncateLargeResponses {
+			return nil, NewAPIError(http.StatusInternalServerError, "response too large")
+		}
+		return out, err
+	}
+}
+
+// NewLoggingHook returns a middleware hook that logs each request's method,
+// path, and JSON body, with the value of any object field named in
+// sensitiveFields replaced by "[REDACTED]". Field names are matched at any
+// depth, ignoring case. Only the logged copy is redacted; the handler still
+// receives the original body. Bodies that are not valid JSON are not logged.
+func NewLoggingHook(logger Logger, sensitiveFields []string) MiddlewareHook {
+	sensitive := make(map[string]bool, len(sensitiveFields))
+	for _, field := range sensitiveFields {
+		sensitive[strings.ToLower(field)] = true
+	}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		body := "(none)"
+		if len(input.Input) > 0 {
+			var value interface{}
+			if err := json.Unmarshal(input.Input, &value); err != nil {
+				body = "(invalid JSON)"
+			} else {
+				redacted, _ := json.Marshal(redactFields(value, sensitive))
+				body = string(redacted)
+			}
+		}
+		logger.Printf("%s %s body=%s\n", input.Method, input.Path, body)
+		return input, nil
+	}
+}
+
+// redactFields returns a copy of a decoded JSON value with the values of the
+// sensitive object fields replaced.
+func redactFields(value interface{}, sensitive map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			if sensitive[strings.ToLower(key)] {
+				redacted[key] = "[REDACTED]"
+			} else {
+				redacted[key] = redactFields(field, sensitive)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactFields(item, sensitive)
+		}
+		return redacted
+	}
+	return value
+}
+
+// NewTimeoutHook returns a middleware hook that gives the rest of the call a
+// deadline of d, through the context passed to later hooks and the handler.
+// Handlers must watch the context for the deadline to have any effect. The
+// context is cancelled once the call completes.
+func NewTimeoutHook(d time.Duration) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		ctx, cancel := context.WithTimeout(input.Ctx, d)
+		input.Ctx = ctx
+		wrapCall(ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			defer cancel()
+			return next()
+		})
+		return input, nil
+	}
+}
+
+// NewDurationHook returns a middleware hook that logs a warning when the rest
+// of the call, including the handler, takes longer than threshold. The warning
+// includes the handler's function name, the pattern the matched endpoint was
+// registered with, as in the API's own log messages, and the time taken.
+func NewDurationHook(threshold time.Duration, logger Logger) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		start := time.Now()
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			out, err := next()
+			if elapsed := time.Since(start); elapsed > threshold {
+				handler, pattern := "unknown", ""
+				if endpoint := ContextEndpoint(input.Ctx); endpoint != nil {
+					handler, pattern = funcName(endpoint.Handler), endpoint.Pattern
+				}
+				logger.Printf("Slow call to %s (%s) took %v\n", handler, pattern, elapsed)
+			}
+			return out, err
+		})
+		return input, nil
+	}
+}
+
+// NewHeaderHook returns a middleware hook that sets the name response header to
+// value on every response from the endpoints it is added to, including error
+// responses. For example, NewHeaderHook("X-API-Version", "v2") can be added to
+// a group of v2 endpoints.
+func NewHeaderHook(name, value string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		SetResponseHeader(input.Ctx, name, value)
+		return input, nil
+	}
+}
+
+// NewEnrichHook returns a middleware hook that replaces the request context
+// with the one returned by enricher, such as a context with values loaded from
+// a database or feature flag service. If enricher returns an error, the call
+// fails with it.
+func NewEnrichHook(enricher func(context.Context) (context.Context, error)) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		ctx, err := enricher(input.Ctx)
+		if err != nil {
+			return nil, err
+		}
+		input.Ctx = ctx
+		return input, nil
+	}
+}
+
+// NewSampledHook returns a middleware hook that runs hook for a fraction rate
+// of requests, between 0 and 1, and passes the others through unchanged. It
+// can be used to reduce the overhead of detailed logging or tracing.
+//
+// By default each request is sampled at random. If a key function is given,
+// requests are instead sampled by key, so that every request with the same
+// key, such as the same user, is either sampled or not.
+func NewSampledHook(rate float64, hook MiddlewareHook, key ...func(*EndpointInput) string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		var sample float64
+		if len(key) > 0 {
+			h := fnv.New64a()
+			h.Write([]byte(key[0](input)))
+			sample = float64(h.Sum64()>>11) / (1 << 53)
+		} else {
+			sample = rand.Float64()
+		}
+		if sample < rate {
+			return hook(input)
+		}
+		return input, nil
+	}
+}
+
+// A FlagStore reports whether feature flags are enabled, such as from a
+// feature flag service.
+type FlagStore interface {
+	IsEnabled(ctx context.Context, flagName string) bool
+}
+
+// NewFeatureFlagHook returns a middleware hook that runs enabledHook if the
+// flagName flag is enabled in store for the request, and disabledHook
+// otherwise. Either hook may be nil to pass the request through unchanged. This
+// allows changes to authentication, rate limiting, or validation to be rolled
+// out gradually.
+func NewFeatureFlagHook(flagName string, store FlagStore, enabledHook, disabledHook MiddlewareHook) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		hook := disabledHook
+		if store.IsEnabled(input.Ctx, flagName) {
+			hook = enabledHook
+		}
+		if hook == nil {
+			return input, nil
+		}
+		return hook(input)
+	}
+}
+
+// NewPanicToErrorHook returns a middleware hook that recovers from panics in
+// the rest of the call, including later hooks and the handler, and fails the
+// call with status 500 instead. The panic value is logged with the method and
+// path of the request, which API.Call's own recovery does not record.
+func NewPanicToErrorHook() MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (out interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					contextLogger(input.Ctx).Printf("Panic in %s %s: %v\n", input.Method, input.Path, r)
+					out, err = nil, NewAPIError(http.StatusInternalServerError, "internal error")
+				}
+			}()
+			return next()
+		})
+		return input, nil
+	}
+}
+
+// NewRecoveryToErrorHook returns a middleware hook that recovers from panics in
+// the rest of the call, including later hooks and the handler, and fails the
+// call with the error mapper returns for the panic value. If mapper returns nil,
+// the panic continues, to be handled by an earlier recovery hook or by
+// API.Call, so that several hooks can each handle the panics they recognize.
+func NewRecoveryToErrorHook(mapper func(recovered interface{}) *APIError) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (out interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					apiErr := mapper(r)
+					if apiErr == nil {
+						panic(r)
+					}
+					out, err = nil, apiErr
+				}
+			}()
+			return next()
+		})
+		return input, nil
+	}
+}
+
+// NewRetryAfterHook returns a post-request hook that sets the Retry-After
+// response header when a call fails with an error for which retryFn returns a
+// positive duration, rounded up to whole seconds. This tells clients when to
+// retry after temporary failures, such as an unavailable dependency. Add the
+// hook to an endpoint with After.
+func NewRetryAfterHook(retryFn func(err error) time.Duration) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			if d := retryFn(err); d > 0 {
+				setRetryAfter(input.Ctx, d)
+			}
+		}
+		return out, err
+	}
+}
+
+// NewCustomErrorMapper returns a post-request hook that replaces errors for
+// which mapper returns a non-nil *APIError with that error, so that domain
+// errors can be mapped to HTTP statuses without the domain packages depending
+// on dispatch. Errors for which mapper returns nil are handled as usual. Add the
+// hook to an endpoint with After.
+func NewCustomErrorMapper(mapper func(error) *APIError) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			if apiErr := mapper(err); apiErr != nil {
+				return nil, apiErr
+			}
+		}
+		return out, err
+	}
+}
+
+// setRetryAfter sets the Retry-After response header to d, in whole seconds.
+func setRetryAfter(ctx context.Context, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	SetResponseHeader(ctx, "Retry-After", strconv.Itoa(seconds))
+}
+
+// NewDeprecationHook returns a middleware hook that marks the endpoints it is
+// added to as deprecated, as an alternative to setting Endpoint.Deprecated. It
+// sets a "Deprecation: true" response header, a Sunset header with sunsetDate
+// if it is not empty, and a Link header with relation type deprecation if a
+// documentation URL is given. Each call is logged as a warning with message and
+// the pattern the matched endpoint was registered with, as when
+// Endpoint.Deprecated is set.
+func NewDeprecationHook(message, sunsetDate string, docsURL ...string) MiddlewareHook {
+	link := ""
+	if len(docsURL) > 0 {
+		link = docsURL[0]
+	}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		pattern := input.Method + input.Path
+		if endpoint := ContextEndpoint(input.Ctx); endpoint != nil {
+			pattern = endpoint.Pattern
+		}
+		contextLogger(input.Ctx).Printf("Deprecated endpoint %s called: %s\n", pattern, message)
+		setDeprecationHeaders(input.Ctx, sunsetDate, link, "deprecation")
+		return input, nil
+	}
+}
+
+// NewRequestIDHook returns a middleware hook that makes sure every request has
+// an ID, whichever proxy it came through. If the context has no request ID, the
+// hook generates a random UUID and stores it in the context. The ID is also set
+// as the X-Request-ID header of the input, for later hooks that read it from
+// the headers.
+func NewRequestIDHook() MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		id := ContextRequestID(input.Ctx)
+		if id == "" {
+			id = uuid.NewString()
+			input.Ctx = SetContextRequestID(input.Ctx, id)
+		}
+		if input.Headers == nil {
+			input.Headers = http.Header{}
+		}
+		input.Headers.Set("X-Request-ID", id)
+		return input, nil
+	}
+}
+
+// NewVersionHeaderHook returns a post-request hook that sets the X-API-Version
+// response header to version, and the X-Service-Version header to the version
+// of the running service binary, on every response. The service version is the
+// main module's version from the build information, or its VCS revision for
+// development builds, and is omitted if neither is known. The API version is
+// also stored in the context, where post-request hooks that run after this
+// one, such as NewEnvelopeHook, can read it with ContextAPIVersion. Add the
+// hook to an endpoint with After.
+func NewVersionHeaderHook(version string) PostRequestHook {
+	serviceVersion := buildVersion()
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		input.Ctx = SetContextAPIVersion(input.Ctx, version)
+		SetResponseHeader(input.Ctx, "X-API-Version", version)
+		if serviceVersion != "" {
+			SetResponseHeader(input.Ctx, "X-Service-Version", serviceVersion)
+		}
+		return out, err
+	}
+}
+
+// buildVersion returns the version of the running binary from its build
+// information.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// NewRequestBodyLogHook returns a middleware hook that logs the first maxSize
+// bytes of each request body as it is, with the request's method, path, and
+// request ID, for debugging clients at the protocol level. Bodies that are not
+// valid UTF-8 are logged as [binary]. Unlike NewLoggingHook, no fields are
+// redacted, so it should not be used where bodies may contain secrets.
+func NewRequestBodyLogHook(logger Logger, maxSize int) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		body := "[binary]"
+		if utf8.Valid(input.Input) {
+			data, truncated := input.Input, ""
+			if len(data) > maxSize {
+				data, truncated = data[:maxSize], "..."
+			}
+			body = strconv.Quote(string(data)) + truncated
+		}
+		logger.Printf("%s %s request_id=%s body=%s\n", input.Method, input.Path, ContextRequestID(input.Ctx), body)
+		return input, nil
+	}
+}
+
+// overrideMethods are the methods that NewMethodOverrideHook allows.
+var overrideMethods = map[string]bool{"PUT": true, "PATCH": true, "DELETE": true}
+
+// NewMethodOverrideHook returns a middleware hook that lets clients that can
+// only send GET and POST requests call PUT, PATCH and DELETE endpoints. For
+// POST requests, the method is replaced with the value of the _method query
+// parameter, or of the X-HTTP-Method-Override header if there is no query
+// parameter. Overrides other than PUT, PATCH and DELETE fail with status 400,
+// and overrides for other request methods are ignored. Since endpoints are
+// matched after the API's global hooks run, the hook must be added to
+// API.GlobalHooks.
+func NewMethodOverrideHook() MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if input.Method != "POST" {
+			return input, nil
+		}
+		method := input.QueryParams.Get("_method")
+		if method == "" {
+			method = input.Headers.Get("X-HTTP-Method-Override")
+		}
+		if method == "" {
+			return input, nil
+		}
+		method = strings.ToUpper(method)
+		if !overrideMethods[method] {
+			return nil, NewAPIError(http.StatusBadRequest, "invalid method override: "+method)
+		}
+		input.Method = method
+		return input, nil
+	}
+}
@@ -0,0 +1,546 @@
+package dispatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// testLogger is a Logger that records log messages.
+type testLogger struct {
+	bytes.Buffer
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	fmt.Fprintf(&l.Buffer, format, v...)
+}
+
+func TestLogHook(t *testing.T) {
+	logger := &testLogger{}
+	api := API{}
+	api.AddEndpoint("POST/items/{id}", func() {}, LogHook(logger))
+
+	ctx := SetContextRequestID(context.Background(), "req-1")
+	body := `{"data":"` + strings.Repeat("x", 600) + `"}`
+	if _, err := api.Call(ctx, "POST", "/items/7", []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := logger.String()
+	for _, expected := range []string{"POST /items/7", "request_id=req-1", "map[id:7]", `"...`} {
+		if !strings.Contains(logged, expected) {
+			t.Errorf("Expected log to contain %s, got %s", expected, logged)
+		}
+	}
+	if strings.Count(logged, "x") > logBodyLimit {
+		t.Error("Body was not truncated")
+	}
+}
+
+func TestMetricsHook(t *testing.T) {
+	var gotMethod, gotPath string
+	var gotStatus int
+	hook := MetricsHook(func(method, path string, status int, latency time.Duration) {
+		gotMethod, gotPath, gotStatus = method, path, status
+	})
+
+	api := API{}
+	api.AddEndpoint("GET/items/{id}", testAPIErrors, hook)
+	api.Call(context.Background(), "GET", "/items/1", nil)
+	if gotMethod != "GET" || gotPath != "/items/{id}" || gotStatus != 418 {
+		t.Errorf("Unexpected metrics %s %s %d", gotMethod, gotPath, gotStatus)
+	}
+}
+
+func TestRetryHook(t *testing.T) {
+	attempts, failures := 0, 2
+	handler := func() error {
+		attempts++
+		if attempts <= failures {
+			return errTemporary
+		}
+		return nil
+	}
+	retryOn := func(err error) bool { return err == errTemporary }
+
+	api := API{}
+	api.AddEndpoint("GET/retry", handler, NewRetryHook(3, time.Millisecond, retryOn))
+	if _, err := api.Call(context.Background(), "GET", "/retry", nil); err != nil || attempts != 3 {
+		t.Errorf("Expected success after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	attempts, failures = 0, 5
+	if _, err := api.Call(context.Background(), "GET", "/retry", nil); err != errTemporary || attempts != 3 {
+		t.Errorf("Expected failure after 3 attempts, got %v after %d", err, attempts)
+	}
+}
+
+func TestRetryHookRestoresInput(t *testing.T) {
+	var attempts int
+	var bodies []string
+	handler := func(ctx context.Context, body map[string]string) error {
+		attempts++
+		bodies = append(bodies, body["name"])
+		return errTemporary
+	}
+	retryOn := func(err error) bool { return err == errTemporary }
+	appendX := func(input *EndpointInput) (*EndpointInput, error) {
+		var body map[string]string
+		json.Unmarshal(input.Input, &body)
+		body["name"] += "x"
+		input.Input, _ = json.Marshal(body)
+		return input, nil
+	}
+
+	api := API{}
+	api.AddEndpoint("POST/retry", handler, NewRetryHook(3, time.Millisecond, retryOn),
+		NewTimeoutHook(time.Second), appendX)
+	api.Call(context.Background(), "POST", "/retry", []byte(`{"name":"a"}`))
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts after a timeout hook, got %d", attempts)
+	}
+	for _, body := range bodies {
+		if body != "ax" {
+			t.Errorf("Expected each attempt to see the original input, got %v", bodies)
+			break
+		}
+	}
+}
+
+var errTemporary = errors.New("temporary")
+
+func TestTraceHook(t *testing.T) {
+	input := &EndpointInput{Ctx: context.Background(), Headers: http.Header{}}
+	input.Headers.Set("X-B3-TraceId", "abc123")
+	input, err := NewTraceHook("X-B3-TraceId")(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := ContextTraceID(input.Ctx); id != "abc123" {
+		t.Errorf("Expected trace ID abc123, got %s", id)
+	}
+}
+
+func TestCacheControlHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/cached", func() {}, NewCacheControlHook("public, max-age=60"))
+	api.AddEndpoint("GET/error", testAPIErrors, NewCacheControlHook("public, max-age=60"))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/cached", nil))
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+		t.Errorf("Unexpected Cache-Control %q", cc)
+	}
+
+	res, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/cached"})
+	if cc := res.Headers["Cache-Control"]; cc != "public, max-age=60" {
+		t.Errorf("Unexpected Cache-Control %q", cc)
+	}
+
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/error", nil))
+	if cc := rec.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("Unexpected Cache-Control %q on error", cc)
+	}
+}
+
+func TestRequestSizeHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/upload", func() {}, NewRequestSizeHook(8))
+	if _, err := api.Call(context.Background(), "POST", "/upload", []byte(`"small"`)); err != nil {
+		t.Error(err)
+	}
+	_, err := api.Call(context.Background(), "POST", "/upload", []byte(`"too large"`))
+	if ErrorStatusCode(err) != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %v", err)
+	}
+}
+
+func TestLoggingHook(t *testing.T) {
+	logger := &testLogger{}
+	var received string
+	handler := func(in map[string]interface{}) {
+		received, _ = in["password"].(string)
+	}
+	api := API{}
+	api.AddEndpoint("POST/login", handler, NewLoggingHook(logger, []string{"password", "token"}))
+
+	body := `{"user":"alice","password":"hunter2","session":{"Token":"abc"}}`
+	if _, err := api.Call(context.Background(), "POST", "/login", []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	logged := logger.String()
+	if strings.Contains(logged, "hunter2") || strings.Contains(logged, "abc") {
+		t.Errorf("Sensitive fields were logged: %s", logged)
+	}
+	if !strings.Contains(logged, `"alice"`) || strings.Count(logged, "[REDACTED]") != 2 {
+		t.Errorf("Unexpected log %s", logged)
+	}
+	if received != "hunter2" {
+		t.Errorf("Handler received %q", received)
+	}
+}
+
+func TestTimeoutHook(t *testing.T) {
+	var handlerCtx context.Context
+	handler := func(ctx context.Context) error {
+		handlerCtx = ctx
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+	api := API{}
+	api.AddEndpoint("GET/slow", handler, NewTimeoutHook(10*time.Millisecond))
+	if _, err := api.Call(context.Background(), "GET", "/slow", nil); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	api = API{}
+	api.AddEndpoint("GET/fast", func(ctx context.Context) { handlerCtx = ctx }, NewTimeoutHook(time.Minute))
+	api.Call(context.Background(), "GET", "/fast", nil)
+	if handlerCtx.Err() != context.Canceled {
+		t.Errorf("Expected context to be cancelled after the call, got %v", handlerCtx.Err())
+	}
+}
+
+func slowHandler() {
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestDurationHook(t *testing.T) {
+	logger := &testLogger{}
+	api := API{}
+	api.AddEndpoint("GET/slow", slowHandler, NewDurationHook(10*time.Millisecond, logger))
+	api.AddEndpoint("GET/fast", func() {}, NewDurationHook(time.Second, logger))
+
+	api.Call(context.Background(), "GET", "/fast", nil)
+	if logger.Len() != 0 {
+		t.Errorf("Unexpected log %s", logger.String())
+	}
+	api.Call(context.Background(), "GET", "/slow", nil)
+	logged := logger.String()
+	if !strings.Contains(logged, "dispatch.slowHandler") || !strings.Contains(logged, "GET/slow") {
+		t.Errorf("Unexpected log %s", logged)
+	}
+}
+
+func TestHeaderHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/v2/items", testAPIErrors, NewHeaderHook("X-API-Version", "v2"))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/v2/items", nil))
+	if v := rec.Header().Get("X-API-Version"); v != "v2" {
+		t.Errorf("Unexpected X-API-Version %q", v)
+	}
+	res, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/v2/items"})
+	if v := res.Headers["X-Api-Version"]; v != "v2" {
+		t.Errorf("Unexpected headers %v", res.Headers)
+	}
+}
+
+func TestEnrichHook(t *testing.T) {
+	enricher := func(ctx context.Context) (context.Context, error) {
+		if ContextRequestID(ctx) == "" {
+			return nil, errTemporary
+		}
+		return SetContextTraceID(ctx, "trace-"+ContextRequestID(ctx)), nil
+	}
+	api := API{}
+	api.AddEndpoint("GET/trace", func(ctx context.Context) string { return ContextTraceID(ctx) }, NewEnrichHook(enricher))
+
+	out, err := api.Call(SetContextRequestID(context.Background(), "1"), "GET", "/trace", nil)
+	if out != "trace-1" || err != nil {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+	if _, err := api.Call(context.Background(), "GET", "/trace", nil); err != errTemporary {
+		t.Errorf("Expected enricher error, got %v", err)
+	}
+}
+
+func TestSampledHook(t *testing.T) {
+	calls := 0
+	counter := func(input *EndpointInput) (*EndpointInput, error) {
+		calls++
+		return input, nil
+	}
+	input := &EndpointInput{Ctx: context.Background(), Path: "/items"}
+
+	for _, rate := range []float64{0, 1} {
+		calls = 0
+		hook := NewSampledHook(rate, counter)
+		for i := 0; i < 100; i++ {
+			hook(input)
+		}
+		if calls != int(rate*100) {
+			t.Errorf("Rate %v: hook ran %d times", rate, calls)
+		}
+	}
+
+	calls = 0
+	hook := NewSampledHook(0.5, counter, func(input *EndpointInput) string { return input.Path })
+	for i := 0; i < 100; i++ {
+		hook(input)
+	}
+	if calls != 0 && calls != 100 {
+		t.Errorf("Expected consistent sampling by key, hook ran %d times", calls)
+	}
+}
+
+// testFlagStore is a FlagStore backed by a map.
+type testFlagStore map[string]bool
+
+func (s testFlagStore) IsEnabled(ctx context.Context, flagName string) bool {
+	return s[flagName]
+}
+
+func TestFeatureFlagHook(t *testing.T) {
+	store := testFlagStore{}
+	reject := func(input *EndpointInput) (*EndpointInput, error) {
+		return nil, errTemporary
+	}
+	api := API{}
+	api.AddEndpoint("GET/new", func() {}, NewFeatureFlagHook("strict", store, reject, nil))
+
+	if _, err := api.Call(context.Background(), "GET", "/new", nil); err != nil {
+		t.Errorf("Expected disabled flag to pass through, got %v", err)
+	}
+	store["strict"] = true
+	if _, err := api.Call(context.Background(), "GET", "/new", nil); err != errTemporary {
+		t.Errorf("Expected enabled hook to run, got %v", err)
+	}
+}
+
+func TestPanicToErrorHook(t *testing.T) {
+	panicHook := func(input *EndpointInput) (*EndpointInput, error) {
+		var input2 *EndpointInput
+		return input2.Ctx.Value(nil).(*EndpointInput), nil
+	}
+	logger := &testLogger{}
+	api := API{Logger: logger}
+	api.AddEndpoint("GET/hook", func() {}, NewPanicToErrorHook(), panicHook)
+	api.AddEndpoint("GET/handler", func() { panic("handler failed") }, NewPanicToErrorHook())
+
+	for _, path := range []string{"/hook", "/handler"} {
+		_, err := api.Call(context.Background(), "GET", path, nil)
+		if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusInternalServerError {
+			t.Errorf("%s: expected 500 API error, got %v", path, err)
+		}
+	}
+	if !strings.Contains(logger.String(), "Panic in GET /handler: handler failed") {
+		t.Errorf("Expected panic to be logged, got %q", logger.String())
+	}
+}
+
+func TestRetryAfterHook(t *testing.T) {
+	retryFn := func(err error) time.Duration {
+		if err == errTemporary {
+			return 1500 * time.Millisecond
+		}
+		return 0
+	}
+	api := API{}
+	api.AddEndpoint("GET/temporary", func() error { return errTemporary }, After(NewRetryAfterHook(retryFn)))
+	api.AddEndpoint("GET/permanent", testAPIErrors, After(NewRetryAfterHook(retryFn)))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/temporary", nil))
+	if v := rec.Header().Get("Retry-After"); v != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", v)
+	}
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/permanent", nil))
+	if v := rec.Header().Get("Retry-After"); v != "" {
+		t.Errorf("Unexpected Retry-After %q", v)
+	}
+}
+
+func TestDeprecationHook(t *testing.T) {
+	hook := NewDeprecationHook("use /v2/items", "Wed, 02 Jan 2030 00:00:00 GMT", "https://example.com/docs")
+	logger := &testLogger{}
+	api := API{Logger: logger}
+	api.AddEndpoint("GET/v1/items", func() {}, hook)
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/v1/items", nil))
+	header := rec.Header()
+	if header.Get("Deprecation") != "true" || header.Get("Sunset") != "Wed, 02 Jan 2030 00:00:00 GMT" ||
+		header.Get("Link") != `<https://example.com/docs>; rel="deprecation"` {
+		t.Errorf("Unexpected headers %v", header)
+	}
+	if !strings.Contains(logger.String(), "Deprecated endpoint GET/v1/items called: use /v2/items") {
+		t.Errorf("Expected deprecated call to be logged, got %q", logger.String())
+	}
+}
+
+func TestRequestIDHook(t *testing.T) {
+	handler := func(ctx context.Context) string { return ContextRequestID(ctx) }
+	api := API{}
+	api.AddEndpoint("GET/id", handler, NewRequestIDHook())
+
+	out, _ := api.Call(context.Background(), "GET", "/id", nil)
+	if id, _ := out.(string); len(id) != 36 {
+		t.Errorf("Expected a generated UUID, got %v", out)
+	}
+	out, _ = api.Call(SetContextRequestID(context.Background(), "req-1"), "GET", "/id", nil)
+	if out != "req-1" {
+		t.Errorf("Expected existing request ID to be kept, got %v", out)
+	}
+
+	input := &EndpointInput{Ctx: context.Background()}
+	input, _ = NewRequestIDHook()(input)
+	if input.Headers.Get("X-Request-ID") != ContextRequestID(input.Ctx) {
+		t.Errorf("Expected header to match context, got %v", input.Headers)
+	}
+}
+
+func TestVersionHeaderHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/items", testAPIErrors, After(NewVersionHeaderHook("v2")))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/items", nil))
+	if v := rec.Header().Get("X-API-Version"); v != "v2" {
+		t.Errorf("Unexpected X-API-Version %q", v)
+	}
+	if v := rec.Header().Get("X-Service-Version"); v != buildVersion() {
+		t.Errorf("Unexpected X-Service-Version %q", v)
+	}
+}
+
+func TestRequestBodyLogHook(t *testing.T) {
+	logger := &testLogger{}
+	api := API{}
+	api.AddEndpoint("POST/raw", func() {}, NewRequestBodyLogHook(logger, 8))
+
+	ctx := SetContextRequestID(context.Background(), "req-1")
+	api.Call(ctx, "POST", "/raw", []byte(`{"data":"long"}`))
+	if logged := logger.String(); !strings.Contains(logged, `POST /raw request_id=req-1 body="{\"data\":"...`) {
+		t.Errorf("Unexpected log %s", logged)
+	}
+
+	logger.Reset()
+	api.Call(ctx, "POST", "/raw", []byte{0xff, 0xfe, 0x00})
+	if logged := logger.String(); !strings.Contains(logged, "body=[binary]") {
+		t.Errorf("Unexpected log %s", logged)
+	}
+}
+
+func TestMethodOverrideHook(t *testing.T) {
+	api := API{GlobalHooks: []MiddlewareHook{NewMethodOverrideHook()}}
+	api.AddEndpoint("POST/items/{id}", func() string { return "post" })
+	api.AddEndpoint("DELETE/items/{id}", func() string { return "delete" })
+	api.AddEndpoint("PATCH/items/{id}", func() string { return "patch" })
+
+	request := func(method, target, override string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if override != "" {
+			req.Header.Set("X-HTTP-Method-Override", override)
+		}
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, req)
+		return rec
+	}
+	if rec := request("POST", "/items/1?_method=delete", ""); rec.Body.String() != `"delete"` {
+		t.Errorf("Expected query override, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := request("POST", "/items/1", "PATCH"); rec.Body.String() != `"patch"` {
+		t.Errorf("Expected header override, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := request("POST", "/items/1", ""); rec.Body.String() != `"post"` {
+		t.Errorf("Expected no override, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := request("POST", "/items/1", "GET"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unsafe override, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := request("DELETE", "/items/1", "PATCH"); rec.Body.String() != `"delete"` {
+		t.Errorf("Expected override of non-POST request to be ignored, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCustomErrorMapper(t *testing.T) {
+	errOutOfStock := errors.New("out of stock")
+	mapper := func(err error) *APIError {
+		if errors.Is(err, errOutOfStock) {
+			return NewAPIError(http.StatusConflict, "item is out of stock")
+		}
+		return nil
+	}
+	api := API{}
+	api.AddEndpoint("POST/orders", func() error { return fmt.Errorf("order: %w", errOutOfStock) }, After(NewCustomErrorMapper(mapper)))
+	api.AddEndpoint("POST/other", func() error { return errTemporary }, After(NewCustomErrorMapper(mapper)))
+
+	_, err := api.Call(context.Background(), "POST", "/orders", nil)
+	if ErrorStatusCode(err) != http.StatusConflict || err.Error() != "item is out of stock" {
+		t.Errorf("Expected mapped error, got %v", err)
+	}
+	if _, err := api.Call(context.Background(), "POST", "/other", nil); err != errTemporary {
+		t.Errorf("Expected unmapped error, got %v", err)
+	}
+}
+
+func TestRecoveryToErrorHook(t *testing.T) {
+	errConflict := errors.New("conflict")
+	mapConflict := func(r interface{}) *APIError {
+		if r == errConflict {
+			return NewAPIError(http.StatusConflict, "conflict")
+		}
+		return nil
+	}
+	mapAll := func(r interface{}) *APIError {
+		return NewAPIError(http.StatusServiceUnavailable, fmt.Sprint(r))
+	}
+	api := API{}
+	api.AddEndpoint("GET/conflict", func() { panic(errConflict) }, NewRecoveryToErrorHook(mapAll), NewRecoveryToErrorHook(mapConflict))
+	api.AddEndpoint("GET/other", func() { panic("other") }, NewRecoveryToErrorHook(mapAll), NewRecoveryToErrorHook(mapConflict))
+	api.AddEndpoint("GET/unhandled", func() { panic("other") }, NewRecoveryToErrorHook(mapConflict))
+
+	for path, expected := range map[string]int{
+		"/conflict":  http.StatusConflict,
+		"/other":     http.StatusServiceUnavailable,
+		"/unhandled": http.StatusInternalServerError,
+	} {
+		_, err := api.Call(context.Background(), "GET", path, nil)
+		if ErrorStatusCode(err) != expected {
+			t.Errorf("%s: expected %d, got %v", path, expected, err)
+		}
+	}
+}
+
+func TestResponseSizeHook(t *testing.T) {
+	for _, truncate := range []bool{false, true} {
+		logger := &testLogger{}
+		api := API{TruncateLargeResponses: truncate}
+		api.AddEndpoint("GET/small", func() string { return "ok" }, After(NewResponseSizeHook(10, logger)))
+		api.AddEndpoint("GET/large", func() string { return strings.Repeat("x", 20) }, After(NewResponseSizeHook(10, logger)))
+
+		if out, err := api.Call(context.Background(), "GET", "/small", nil); out != "ok" || err != nil {
+			t.Errorf("Unexpected result %v, %v", out, err)
+		}
+		if logger.Len() != 0 {
+			t.Errorf("Unexpected log output %q", logger.String())
+		}
+		out, err := api.Call(context.Background(), "GET", "/large", nil)
+		if !strings.Contains(logger.String(), "Response to GET /large is 22 bytes, over the limit of 10") {
+			t.Errorf("Unexpected log output %q", logger.String())
+		}
+		if truncate && (out != nil || ErrorStatusCode(err) != http.StatusInternalServerError) {
+			t.Errorf("Expected large response to be replaced with an error, got %v, %v", out, err)
+		}
+		if !truncate && (out == nil || err != nil) {
+			t.Errorf("Expected large response to be sent, got %v, %v", out, err)
+		}
+	}
+}
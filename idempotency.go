@@ -0,0 +1,158 @@
+package dispatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// An IdempotentResponse is a response saved by NewIdempotencyHook, to be sent
+// again for retries of the same request. InputHash is the hex-encoded SHA-256
+// hash of the request body it was saved for.
+type IdempotentResponse struct {
+	StatusCode int
+	Body       json.RawMessage
+	InputHash  string
+}
+
+// An IdempotencyStore saves responses by idempotency key. Get returns false if
+// there is no unexpired response for key. Implementations backed by a shared
+// store such as Redis allow retries to be handled by any instance of an API.
+type IdempotencyStore interface {
+	Get(key string) (resp IdempotentResponse, ok bool, err error)
+	Set(key string, resp IdempotentResponse, ttl time.Duration) error
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps responses in memory.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]memoryIdempotentResponse
+}
+
+type memoryIdempotentResponse struct {
+	resp    IdempotentResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{responses: make(map[string]memoryIdempotentResponse)}
+}
+
+func (s *MemoryIdempotencyStore) Get(key string) (IdempotentResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, ok := s.responses[key]
+	if !ok || time.Now().After(saved.expires) {
+		delete(s.responses, key)
+		return IdempotentResponse{}, false, nil
+	}
+	return saved.resp, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Set(key string, resp IdempotentResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, saved := range s.responses {
+		if now.After(saved.expires) {
+			delete(s.responses, k)
+		}
+	}
+	s.responses[key] = memoryIdempotentResponse{resp, now.Add(ttl)}
+	return nil
+}
+
+// NewIdempotencyHook returns a middleware hook that makes retries of a request
+// safe, for the endpoints it is added to. Requests with an Idempotency-Key
+// header that match a successful response saved in store within ttl get that
+// response again, without running the rest of the call. Otherwise, successful
+// responses are saved in store, except for upstream responses and *Response
+// outputs with headers. Keys are scoped to the request method and path, and
+// to the caller, identified by the tenant, API key owner and JWT sub claim in
+// the context, or by client IP address if there are none. The hook must be
+// added after any hooks that store those.
+// A request that reuses a key with a different body fails with status 422.
+// Requests without the header are not affected.
+//
+// Concurrent requests with the same key are not deduplicated, so they may all
+// run. Errors from store are logged, and the request is handled as if it had no
+// saved response.
+func NewIdempotencyHook(store IdempotencyStore, ttl time.Duration) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		idempotencyKey := input.Headers.Get("Idempotency-Key")
+		if idempotencyKey == "" {
+			return input, nil
+		}
+		key := input.Method + " " + input.Path + " " + idempotencyCaller(input) + " " + idempotencyKey
+		hash := sha256.Sum256(input.Input)
+		inputHash := hex.EncodeToString(hash[:])
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			saved, ok, err := store.Get(key)
+			if err != nil {
+				contextLogger(input.Ctx).Printf("Idempotency store error for %s %s: %v\n", input.Method, input.Path, err)
+			}
+			if ok && saved.InputHash != inputHash {
+				return nil, NewAPIError(http.StatusUnprocessableEntity, "idempotency key reused with a different request")
+			}
+			if ok {
+				resp := &Response{StatusCode: saved.StatusCode}
+				if saved.Body != nil {
+					resp.Body = saved.Body
+				}
+				return resp, nil
+			}
+
+			out, err := next()
+			if err != nil {
+				return out, err
+			}
+			if resp, ok := idempotentResponse(out); ok {
+				resp.InputHash = inputHash
+				if err := store.Set(key, resp, ttl); err != nil {
+					contextLogger(input.Ctx).Printf("Idempotency store error for %s %s: %v\n", input.Method, input.Path, err)
+				}
+			}
+			return out, nil
+		})
+		return input, nil
+	}
+}
+
+// idempotencyCaller identifies the caller of a request for scoping idempotency
+// keys. Anonymous requests are identified by client IP address.
+func idempotencyCaller(input *EndpointInput) string {
+	tenant, owner := ContextTenant(input.Ctx).ID, ContextAPIKeyOwner(input.Ctx)
+	sub, _ := ContextJWTClaims(input.Ctx)["sub"].(string)
+	if tenant == "" && owner == "" && sub == "" {
+		return fmt.Sprintf("ip=%q", input.RemoteAddr)
+	}
+	return fmt.Sprintf("tenant=%q owner=%q sub=%q", tenant, owner, sub)
+}
+
+// idempotentResponse converts a call's output to the response to save for it,
+// returning false if it cannot be saved.
+func idempotentResponse(out interface{}) (IdempotentResponse, bool) {
+	statusCode, body := http.StatusOK, out
+	switch resp := out.(type) {
+	case *http.Response, responseWritten:
+		return IdempotentResponse{}, false
+	case *Response:
+		if resp == nil || len(resp.Headers) > 0 {
+			return IdempotentResponse{}, false
+		}
+		if resp.Body == nil {
+			return IdempotentResponse{StatusCode: resp.statusCode()}, true
+		}
+		statusCode, body = resp.statusCode(), resp.Body
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return IdempotentResponse{}, false
+	}
+	return IdempotentResponse{StatusCode: statusCode, Body: encoded}, true
+}
@@ -0,0 +1,79 @@
+package dispatch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyHook(t *testing.T) {
+	calls := 0
+	handler := func() *Response {
+		calls++
+		return &Response{StatusCode: http.StatusCreated, Body: map[string]int{"order": calls}}
+	}
+	api := API{}
+	api.AddEndpoint("POST/orders", handler, NewIdempotencyHook(NewMemoryIdempotencyStore(), time.Minute))
+
+	request := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{}`))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, req)
+		return rec
+	}
+
+	first := request("k1")
+	retry := request("k1")
+	if calls != 1 || retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected retry to get saved response, got %d %s after %d calls", retry.Code, retry.Body, calls)
+	}
+	request("k2")
+	request("")
+	request("")
+	if calls != 4 {
+		t.Errorf("Expected other requests to run, got %d calls", calls)
+	}
+}
+
+func TestIdempotencyHookScope(t *testing.T) {
+	calls := 0
+	handler := func() map[string]int {
+		calls++
+		return map[string]int{"order": calls}
+	}
+	api := API{}
+	api.AddEndpoint("POST/orders", handler, NewIdempotencyHook(NewMemoryIdempotencyStore(), time.Minute))
+
+	call := func(sub, body string) (interface{}, error) {
+		req := httptest.NewRequest("POST", "/orders", nil)
+		req.Header.Set("Idempotency-Key", "k1")
+		ctx := SetContextHTTPRequest(context.Background(), req)
+		ctx = SetContextJWTClaims(ctx, JWTClaims{"sub": sub})
+		return api.Call(ctx, "POST", "/orders", []byte(body))
+	}
+	call("u1", `{"n":1}`)
+	if _, err := call("u2", `{"n":1}`); err != nil || calls != 2 {
+		t.Errorf("Expected another caller's request to run, got %v after %d calls", err, calls)
+	}
+	if _, err := call("u1", `{"n":2}`); ErrorStatusCode(err) != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a reused key with a different body, got %v", err)
+	}
+	if _, err := call("u1", `{"n":1}`); err != nil || calls != 2 {
+		t.Errorf("Expected a retry to get the saved response, got %v after %d calls", err, calls)
+	}
+}
+
+func TestMemoryIdempotencyStoreExpiry(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	store.Set("k", IdempotentResponse{StatusCode: 200}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := store.Get("k"); ok {
+		t.Error("Expected saved response to expire")
+	}
+}
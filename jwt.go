@@ -0,0 +1,233 @@
+package dispatch
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksTTL is how long fetched signing keys are cached.
+const jwksTTL = time.Hour
+
+// jwksMinRefresh limits how often unknown key IDs can trigger a refetch.
+const jwksMinRefresh = time.Minute
+
+// jwksRetryInterval is how long to wait after a failed fetch before trying
+// again.
+const jwksRetryInterval = 10 * time.Second
+
+// jwksClient fetches key sets, with a timeout so that an unresponsive JWKS
+// endpoint cannot hold up authenticated requests indefinitely.
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+var errInvalidToken = NewAPIError(http.StatusUnauthorized, "invalid token")
+
+// NewCognitoHook returns a middleware hook that verifies the Cognito JWT in
+// the request's "Authorization: Bearer <token>" header and stores its claims in
+// the context, where handlers can read them with ContextJWTClaims.
+//
+// The user pool's signing keys are fetched from its JWKS endpoint and cached
+// for an hour, and are refetched early when a token uses an unknown key ID.
+// Requests with a missing, invalid, or expired token fail with status 401.
+func NewCognitoHook(userPoolID, region string) MiddlewareHook {
+	issuer := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, userPoolID)
+	return newJWKSHook(issuer+"/.well-known/jwks.json", issuer)
+}
+
+// newJWKSHook returns a hook that verifies RS256 JWTs signed by a key from
+// jwksURL and issued by issuer.
+func newJWKSHook(jwksURL, issuer string) MiddlewareHook {
+	keys := &jwksCache{url: jwksURL}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		auth := input.Headers.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, errInvalidToken
+		}
+		claims, err := verifyJWT(input.Ctx, strings.TrimPrefix(auth, "Bearer "), issuer, keys)
+		if err != nil {
+			return nil, err
+		}
+		input.Ctx = SetContextJWTClaims(input.Ctx, claims)
+		return input, nil
+	}
+}
+
+// verifyJWT checks the signature, issuer, and expiry of an RS256 token and
+// returns its claims.
+func verifyJWT(ctx context.Context, token, issuer string, keys *jwksCache) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "RS256" {
+		return nil, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	key, err := keys.get(header.Kid)
+	if err != nil {
+		contextLogger(ctx).Printf("Fetching JWKS from %s: %v\n", keys.url, err)
+		return nil, ErrInternal
+	}
+	if key == nil {
+		return nil, errInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+		return nil, errInvalidToken
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return nil, errInvalidToken
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// decodeJWTPart unmarshals a base64url-encoded JSON segment of a JWT.
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwksCache fetches and caches the RSA signing keys published at a JWKS URL.
+type jwksCache struct {
+	url string
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	// refreshing is closed when the fetch in progress, if any, completes.
+	// refreshErr is the error from the last fetch, which failed at failed.
+	refreshing chan struct{}
+	refreshErr error
+	failed     time.Time
+}
+
+// get returns the key with the given ID, refreshing the cache if it has
+// expired or does not contain the key. It returns nil if there is no such key.
+// Concurrent refreshes share a single fetch, which is made without holding the
+// lock, and callers that already have a cached key do not wait for it. If a
+// fetch fails, cached keys are still returned, and no other fetch is made for
+// jwksRetryInterval; callers without a cached key get the fetch error.
+func (c *jwksCache) get(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	age := time.Since(c.fetched)
+	key, ok := c.keys[kid]
+	if age <= jwksTTL && (ok || age <= jwksMinRefresh) {
+		c.mu.Unlock()
+		return key, nil
+	}
+	if c.refreshErr != nil && time.Since(c.failed) < jwksRetryInterval {
+		defer c.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		return nil, c.refreshErr
+	}
+
+	if done := c.refreshing; done != nil {
+		c.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		<-done
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.cachedKey(kid)
+	}
+	done := make(chan struct{})
+	c.refreshing = done
+	c.mu.Unlock()
+
+	keys, err := fetchJWKS(c.url)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.keys = keys
+		c.fetched = time.Now()
+	} else {
+		c.failed = time.Now()
+	}
+	c.refreshErr = err
+	c.refreshing = nil
+	close(done)
+	return c.cachedKey(kid)
+}
+
+// cachedKey returns the cached key with the given ID after a refresh, or the
+// refresh error if there is no such key. The lock must be held.
+func (c *jwksCache) cachedKey(kid string) (*rsa.PublicKey, error) {
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, c.refreshErr
+}
+
+// fetchJWKS fetches the RSA keys in the key set at url, by key ID.
+func fetchJWKS(url string) (map[string]*rsa.PublicKey, error) {
+	resp, err := jwksClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			return nil, errors.New("invalid RSA key " + k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,139 @@
+package dispatch
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims JWTClaims) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWKSHook(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	issuer := "https://issuer.example.com"
+	hook := newJWKSHook(server.URL, issuer)
+	call := func(token string) (*EndpointInput, error) {
+		input := &EndpointInput{Ctx: context.Background(), Headers: http.Header{}}
+		input.Headers.Set("Authorization", "Bearer "+token)
+		return hook(input)
+	}
+
+	valid := signTestJWT(t, key, "key1", JWTClaims{
+		"sub": "user1",
+		"iss": issuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	input, err := call(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub := ContextJWTClaims(input.Ctx)["sub"]; sub != "user1" {
+		t.Errorf("Expected sub user1, got %v", sub)
+	}
+
+	invalid := []string{
+		"not.a.token",
+		valid[:len(valid)-4] + "AAAA",
+		signTestJWT(t, key, "key1", JWTClaims{"iss": issuer, "exp": time.Now().Add(-time.Hour).Unix()}),
+		signTestJWT(t, key, "key1", JWTClaims{"iss": "https://other.example.com", "exp": time.Now().Add(time.Hour).Unix()}),
+		signTestJWT(t, key, "unknown", JWTClaims{"iss": issuer, "exp": time.Now().Add(time.Hour).Unix()}),
+	}
+	for i, token := range invalid {
+		_, err := call(token)
+		if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Token %d: expected 401, got %v", i, err)
+		}
+	}
+}
+
+func TestJWKSCacheCoalescesFetches(t *testing.T) {
+	var mu sync.Mutex
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	cache := &jwksCache{url: server.URL}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.get("key1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Errorf("Expected concurrent lookups to share one fetch, got %d", fetches)
+	}
+}
+
+func TestJWKSCacheFetchFailure(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cached := &rsa.PublicKey{}
+	cache := &jwksCache{
+		url:     server.URL,
+		keys:    map[string]*rsa.PublicKey{"key1": cached},
+		fetched: time.Now().Add(-2 * jwksTTL),
+	}
+	if key, err := cache.get("key1"); key != cached || err != nil {
+		t.Errorf("Expected the cached key when the refresh fails, got %v, %v", key, err)
+	}
+	if _, err := cache.get("key2"); err == nil {
+		t.Error("Expected the fetch error for an unknown key")
+	}
+	if key, err := cache.get("key1"); key != cached || err != nil {
+		t.Errorf("Expected the cached key while backing off, got %v, %v", key, err)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected no fetches while backing off, got %d", fetches)
+	}
+}
@@ -0,0 +1,240 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Pagination holds the validated pagination parameters of a list request. Page
+// numbers start at 1.
+type Pagination struct {
+	Page     int
+	PageSize int
+}
+
+// Offset returns the number of items before the page.
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// NewPaginationHook returns a middleware hook that reads the page and page_size
+// query parameters and stores them in the context, where handlers can read them
+// with ContextPagination. Missing parameters default to page 1 and
+// defaultPageSize, and page sizes larger than maxPageSize are reduced to it.
+// Requests with parameters that are not positive integers fail with status
+// 400.
+func NewPaginationHook(defaultPageSize, maxPageSize int) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		page, err := positiveQueryParam(input, "page", 1)
+		if err != nil {
+			return nil, err
+		}
+		pageSize, err := positiveQueryParam(input, "page_size", defaultPageSize)
+		if err != nil {
+			return nil, err
+		}
+		if pageSize > maxPageSize {
+			pageSize = maxPageSize
+		}
+		input.Ctx = SetContextPagination(input.Ctx, Pagination{Page: page, PageSize: pageSize})
+		return input, nil
+	}
+}
+
+// positiveQueryParam parses the named query parameter as a positive integer,
+// returning def if it is missing.
+func positiveQueryParam(input *EndpointInput, name string, def int) (int, error) {
+	value := input.QueryParams.Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, NewAPIError(http.StatusBadRequest, "invalid "+name+": must be a positive integer")
+	}
+	return n, nil
+}
+
+// SortParams holds the validated sort parameters of a list request. Direction
+// is either "asc" or "desc".
+type SortParams struct {
+	Field     string
+	Direction string
+}
+
+// NewSortHook returns a middleware hook that reads the sort and order query
+// parameters and stores them in the context, where handlers can read them with
+// ContextSortParams. The order defaults to asc. Requests that sort by a field
+// not in allowedFields, or with an order other than asc or desc, fail with
+// status 400. If there is no sort parameter, no sort parameters are stored.
+func NewSortHook(allowedFields []string) MiddlewareHook {
+	allowed := make(map[string]bool, len(allowedFields))
+	for _, field := range allowedFields {
+		allowed[field] = true
+	}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		field := input.QueryParams.Get("sort")
+		if field == "" {
+			return input, nil
+		}
+		if !allowed[field] {
+			return nil, NewAPIError(http.StatusBadRequest, "invalid sort field")
+		}
+		direction := strings.ToLower(input.QueryParams.Get("order"))
+		switch direction {
+		case "":
+			direction = "asc"
+		case "asc", "desc":
+		default:
+			return nil, NewAPIError(http.StatusBadRequest, "invalid sort order")
+		}
+		input.Ctx = SetContextSortParams(input.Ctx, SortParams{Field: field, Direction: direction})
+		return input, nil
+	}
+}
+
+// NewSearchHook returns a middleware hook that reads the q query parameter,
+// with surrounding whitespace removed, and stores it in the context, where
+// handlers can read it with ContextSearchQuery. Requests with a query longer
+// than maxQueryLen characters fail with status 400.
+func NewSearchHook(maxQueryLen int) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		q := strings.TrimSpace(input.QueryParams.Get("q"))
+		if utf8.RuneCountInString(q) > maxQueryLen {
+			return nil, NewAPIError(http.StatusBadRequest, "search query too long")
+		}
+		input.Ctx = SetContextSearchQuery(input.Ctx, q)
+		return input, nil
+	}
+}
+
+// A FilterType is the type of value expected for a filter query parameter.
+type FilterType int
+
+const (
+	// FilterString filters are used as-is.
+	FilterString FilterType = iota
+	// FilterInt filters are parsed as an int.
+	FilterInt
+	// FilterTime filters are parsed as a time.Time, in RFC 3339 format or as a
+	// date such as 2024-01-31.
+	FilterTime
+	// FilterBool filters are parsed as a bool, as by strconv.ParseBool.
+	FilterBool
+)
+
+// listParams are the query parameters used by the other list hooks, which
+// NewFilterHook does not treat as filters.
+var listParams = map[string]bool{
+	"page":      true,
+	"page_size": true,
+	"sort":      true,
+	"order":     true,
+	"q":         true,
+	"_method":   true,
+}
+
+// NewFilterHook returns a middleware hook that reads filter query parameters,
+// such as status=active&created_after=2024-01-01, parses each one as the type
+// given for it in schema, and stores them in the context by name, where
+// handlers can read them with ContextFilters. The parameters used by
+// NewPaginationHook, NewSortHook, NewSearchHook, and NewMethodOverrideHook are
+// ignored. Requests with filters that are not in schema, or that cannot be
+// parsed, fail with status 400.
+func NewFilterHook(schema map[string]FilterType) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		filters := make(map[string]interface{})
+		for name := range input.QueryParams {
+			if listParams[name] {
+				continue
+			}
+			filterType, ok := schema[name]
+			if !ok {
+				return nil, NewAPIError(http.StatusBadRequest, "unknown filter: "+name)
+			}
+			value, err := parseFilter(input.QueryParams.Get(name), filterType)
+			if err != nil {
+				return nil, NewAPIError(http.StatusBadRequest, "invalid filter "+name+": "+err.Error())
+			}
+			filters[name] = value
+		}
+		input.Ctx = SetContextFilters(input.Ctx, filters)
+		return input, nil
+	}
+}
+
+// parseFilter parses a filter value as filterType.
+func parseFilter(value string, filterType FilterType) (interface{}, error) {
+	switch filterType {
+	case FilterInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+		return n, nil
+	case FilterTime:
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, errors.New("must be a date or time")
+		}
+		return t, nil
+	case FilterBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("must be true or false")
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+// paginatedResponse is the envelope NewPaginatedResponseHook wraps list
+// outputs in.
+type paginatedResponse struct {
+	Items    interface{} `json:"items"`
+	Total    int64       `json:"total"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"pageSize"`
+}
+
+// NewPaginatedResponseHook returns a post-request hook that wraps the slice
+// output of a successful call in an envelope with the total number of items, as
+// returned by countFn, and the page and page size stored by NewPaginationHook:
+//
+//	{"items": [...], "total": 42, "page": 2, "pageSize": 20}
+//
+// A nil slice is sent as an empty array of items. Outputs that are not slices
+// are left unchanged, and if the output is a *Response, its body is wrapped.
+// Add the hook to an endpoint with After, along with NewPaginationHook.
+func NewPaginatedResponseHook(countFn func(context.Context) int64) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return out, err
+		}
+		return transformOutput(out, func(data interface{}) (interface{}, error) {
+			items := reflect.ValueOf(data)
+			if items.Kind() != reflect.Slice {
+				return data, nil
+			}
+			if items.IsNil() {
+				data = []interface{}{}
+			}
+			pagination := ContextPagination(input.Ctx)
+			return paginatedResponse{
+				Items:    data,
+				Total:    countFn(input.Ctx),
+				Page:     pagination.Page,
+				PageSize: pagination.PageSize,
+			}, nil
+		})
+	}
+}
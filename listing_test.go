@@ -0,0 +1,131 @@
+package dispatch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaginationHook(t *testing.T) {
+	handler := func(ctx context.Context) Pagination { return ContextPagination(ctx) }
+	api := API{}
+	api.AddEndpoint("GET/items", handler, NewPaginationHook(20, 100))
+
+	for query, expected := range map[string]Pagination{
+		"":                       {1, 20},
+		"?page=3&page_size=10":   {3, 10},
+		"?page=2&page_size=1000": {2, 100},
+	} {
+		req := httptest.NewRequest("GET", "/items"+query, nil)
+		out, err := api.Call(SetContextHTTPRequest(context.Background(), req), "GET", "/items", nil)
+		if out != expected || err != nil {
+			t.Errorf("Query %q: expected %v, got %v, %v", query, expected, out, err)
+		}
+	}
+	for _, query := range []string{"?page=0", "?page=x", "?page_size=-1"} {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("GET", "/items"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Query %q: expected 400, got %d", query, rec.Code)
+		}
+	}
+	if offset := (Pagination{Page: 3, PageSize: 10}).Offset(); offset != 20 {
+		t.Errorf("Unexpected offset %d", offset)
+	}
+}
+
+func TestSortHook(t *testing.T) {
+	handler := func(ctx context.Context) SortParams { return ContextSortParams(ctx) }
+	api := API{}
+	api.AddEndpoint("GET/items", handler, NewSortHook([]string{"name", "created"}))
+
+	for query, expected := range map[string]SortParams{
+		"":                         {},
+		"?sort=name":               {"name", "asc"},
+		"?sort=created&order=DESC": {"created", "desc"},
+	} {
+		req := httptest.NewRequest("GET", "/items"+query, nil)
+		out, err := api.Call(SetContextHTTPRequest(context.Background(), req), "GET", "/items", nil)
+		if out != expected || err != nil {
+			t.Errorf("Query %q: expected %v, got %v, %v", query, expected, out, err)
+		}
+	}
+	for _, query := range []string{"?sort=password", "?sort=name&order=up"} {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("GET", "/items"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Query %q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestSearchHook(t *testing.T) {
+	handler := func(ctx context.Context) string { return ContextSearchQuery(ctx) }
+	api := API{}
+	api.AddEndpoint("GET/items", handler, NewSearchHook(5))
+
+	req := httptest.NewRequest("GET", "/items?q=+caf%C3%A9+", nil)
+	out, err := api.Call(SetContextHTTPRequest(context.Background(), req), "GET", "/items", nil)
+	if out != "café" || err != nil {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/items?q=toolong", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+}
+
+func TestFilterHook(t *testing.T) {
+	handler := func(ctx context.Context) map[string]interface{} { return ContextFilters(ctx) }
+	api := API{}
+	api.AddEndpoint("GET/items", handler, NewFilterHook(map[string]FilterType{
+		"status":        FilterString,
+		"priority":      FilterInt,
+		"created_after": FilterTime,
+		"archived":      FilterBool,
+	}))
+
+	req := httptest.NewRequest("GET", "/items?status=active&priority=2&created_after=2024-01-01&archived=false&page=2", nil)
+	out, err := api.Call(SetContextHTTPRequest(context.Background(), req), "GET", "/items", nil)
+	filters, _ := out.(map[string]interface{})
+	if err != nil || len(filters) != 4 || filters["status"] != "active" || filters["priority"] != 2 ||
+		filters["archived"] != false || filters["created_after"] != time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+
+	for _, query := range []string{"?owner=me", "?priority=high", "?created_after=yesterday", "?archived=maybe"} {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("GET", "/items"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Query %q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestPaginatedResponseHook(t *testing.T) {
+	count := func(ctx context.Context) int64 { return 42 }
+	api := API{}
+	api.AddEndpoint("GET/items", func(ctx context.Context) []string {
+		if ContextPagination(ctx).Page > 1 {
+			return nil
+		}
+		return []string{"a", "b"}
+	}, NewPaginationHook(2, 10), After(NewPaginatedResponseHook(count)))
+	api.AddEndpoint("GET/item", func() string { return "a" }, After(NewPaginatedResponseHook(count)))
+
+	for target, expected := range map[string]string{
+		"/items":        `{"items":["a","b"],"total":42,"page":1,"pageSize":2}`,
+		"/items?page=3": `{"items":[],"total":42,"page":3,"pageSize":2}`,
+		"/item":         `"a"`,
+	} {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("GET", target, nil))
+		if body := strings.TrimSpace(rec.Body.String()); body != expected {
+			t.Errorf("%s: expected %s, got %d %s", target, expected, rec.Code, body)
+		}
+	}
+}
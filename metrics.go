@@ -0,0 +1,50 @@
+package dispatch
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// NewDurationMetricHook returns a post-request hook that reports the duration
+// of each call to metric, such as to record it in a metrics system. The
+// duration is measured from the start of API.Call until the hook runs, and so
+// includes the hooks that run before it. metric gets the path pattern of the
+// matched endpoint, such as /users/{id}, rather than the concrete path, so that
+// calls to the same endpoint are grouped together. Add the hook to an endpoint
+// with After, or to API.GlobalHooks to report every call.
+func NewDurationMetricHook(metric func(endpoint, method string, duration time.Duration)) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		metric(endpointPattern(input.Ctx), input.Method, time.Since(callStart(input.Ctx)))
+		return out, err
+	}
+}
+
+// endpointPattern returns the path pattern of the endpoint matched by the
+// current call, without its method, or an empty string if no endpoint was
+// matched.
+func endpointPattern(ctx context.Context) string {
+	endpoint := ContextEndpoint(ctx)
+	if endpoint == nil {
+		return ""
+	}
+	if i := strings.Index(endpoint.Path, "/"); i >= 0 {
+		return endpoint.Path[i:]
+	}
+	return endpoint.Path
+}
+
+// NewErrorCountHook returns a post-request hook that reports each call that
+// fails to counter, with the path pattern of the matched endpoint, as
+// NewDurationMetricHook reports it, and the status code from ErrorStatusCode,
+// such as to feed an error rate dashboard. Successful calls are not reported.
+// Add the hook to an endpoint with After, or to API.GlobalHooks to report every
+// call.
+func NewErrorCountHook(counter func(endpoint, method string, statusCode int)) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			counter(endpointPattern(input.Ctx), input.Method, ErrorStatusCode(err))
+		}
+		return out, err
+	}
+}
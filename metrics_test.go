@@ -0,0 +1,50 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDurationMetricHook(t *testing.T) {
+	var endpoint, method string
+	var duration time.Duration
+	metric := func(e, m string, d time.Duration) {
+		endpoint, method, duration = e, m, d
+	}
+	api := API{GlobalHooks: []MiddlewareHook{After(NewDurationMetricHook(metric))}}
+	api.AddEndpoint("GET/users/{id}", func() { time.Sleep(10 * time.Millisecond) })
+
+	if _, err := api.Call(context.Background(), "GET", "/users/42", nil); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint != "/users/{id}" || method != "GET" || duration < 10*time.Millisecond || duration > time.Second {
+		t.Errorf("Unexpected metric %s %s %v", endpoint, method, duration)
+	}
+
+	// Calls that match no endpoint are reported without a pattern
+	api.Call(context.Background(), "GET", "/missing", nil)
+	if endpoint != "" {
+		t.Errorf("Unexpected endpoint %q", endpoint)
+	}
+}
+
+func TestErrorCountHook(t *testing.T) {
+	var counts []string
+	counter := func(endpoint, method string, statusCode int) {
+		counts = append(counts, fmt.Sprintf("%s %s %d", method, endpoint, statusCode))
+	}
+	api := API{GlobalHooks: []MiddlewareHook{After(NewErrorCountHook(counter))}}
+	api.AddEndpoint("GET/ok", func() {})
+	api.AddEndpoint("GET/teapot/{id}", testAPIErrors)
+	api.AddEndpoint("GET/fail", func() error { return errTemporary })
+
+	for _, path := range []string{"/ok", "/teapot/1", "/fail", "/missing"} {
+		api.Call(context.Background(), "GET", path, nil)
+	}
+	expected := []string{"GET /teapot/{id} 418", "GET /fail 500", "GET  404"}
+	if fmt.Sprint(counts) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, counts)
+	}
+}
@@ -2,26 +2,182 @@ package dispatch
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
+
+	"github.com/google/uuid"
 )
 
 // PathVars is an alias for map[string]string, used for captured path variables.
 type PathVars map[string]string
 
+// get returns the named path variable, or an error if it is missing.
+func (pv PathVars) get(name string) (string, error) {
+	value, ok := pv[name]
+	if !ok {
+		return "", fmt.Errorf("path variable %s not found", name)
+	}
+	return value, nil
+}
+
+// GetInt parses the named path variable as an int.
+func (pv PathVars) GetInt(name string) (int, error) {
+	value, err := pv.get(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("path variable %s is not a valid integer: %q", name, value)
+	}
+	return i, nil
+}
+
+// GetInt64 parses the named path variable as an int64.
+func (pv PathVars) GetInt64(name string) (int64, error) {
+	value, err := pv.get(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("path variable %s is not a valid integer: %q", name, value)
+	}
+	return i, nil
+}
+
+// GetFloat parses the named path variable as a float64.
+func (pv PathVars) GetFloat(name string) (float64, error) {
+	value, err := pv.get(name)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("path variable %s is not a valid number: %q", name, value)
+	}
+	return f, nil
+}
+
+// GetUUID parses the named path variable as a UUID.
+func (pv PathVars) GetUUID(name string) ([16]byte, error) {
+	value, err := pv.get(name)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return [16]byte{}, fmt.Errorf("path variable %s is not a valid UUID: %q", name, value)
+	}
+	return id, nil
+}
+
+// String returns the named path variable, and whether it exists. Unlike
+// indexing the map directly, this distinguishes a missing variable from an
+// empty one.
+func (pv PathVars) String(name string) (string, bool) {
+	value, ok := pv[name]
+	return value, ok
+}
+
+// MustString returns the named path variable, panicking if it does not exist.
+// It is meant for handlers whose path pattern guarantees the variable.
+func (pv PathVars) MustString(name string) string {
+	value, err := pv.get(name)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
 // An APIPath represents a specified path and method, such as GET/users/{uuid}.
 type APIPath struct {
 	PathParts []string
 	Method    string
 }
 
+// pathVarTypes holds the supported path variable types, as used in
+// GET/items/{id:int}, and a function that checks a value for each type.
+var pathVarTypes = map[string]func(pathVars PathVars, name string) error{
+	"int": func(pathVars PathVars, name string) error {
+		_, err := pathVars.GetInt64(name)
+		return err
+	},
+	"float": func(pathVars PathVars, name string) error {
+		_, err := pathVars.GetFloat(name)
+		return err
+	},
+	"uuid": func(pathVars PathVars, name string) error {
+		_, err := pathVars.GetUUID(name)
+		return err
+	},
+}
+
+// parsePathVar returns the name and type of a path variable part such as
+// {id:int}. ok is false if the part is not a path variable.
+func parsePathVar(part string) (name, varType string, ok bool) {
+	if len(part) < 2 || part[0] != '{' || part[len(part)-1] != '}' {
+		return "", "", false
+	}
+	name = part[1 : len(part)-1]
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, varType = name[:i], name[i+1:]
+	}
+	return name, varType, true
+}
+
+// validatePattern checks an endpoint pattern such as GET,POST/users/{id:int}
+// for common mistakes: a missing or malformed method prefix, empty segments
+// (including a trailing or doubled slash), and malformed path variables.
+func validatePattern(pattern string) error {
+	i := strings.Index(pattern, "/")
+	if i < 0 {
+		return fmt.Errorf("invalid pattern %q: missing path", pattern)
+	}
+	methods, path := pattern[:i], pattern[i+1:]
+	if methods == "" {
+		return fmt.Errorf("invalid pattern %q: missing method prefix", pattern)
+	}
+	for _, method := range strings.Split(methods, ",") {
+		method = strings.TrimSpace(method)
+		if method == "" || strings.IndexFunc(method, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+			return fmt.Errorf("invalid pattern %q: invalid method %q", pattern, method)
+		}
+	}
+	for _, part := range strings.Split(path, "/") {
+		if part == "" {
+			return fmt.Errorf("invalid pattern %q: empty path segment", pattern)
+		}
+		if !strings.ContainsAny(part, "{}") {
+			continue
+		}
+		name, _, ok := parsePathVar(part)
+		if !ok || strings.ContainsAny(part[1:len(part)-1], "{}") {
+			return fmt.Errorf("invalid pattern %q: unclosed brace in segment %q", pattern, part)
+		}
+		if name == "" {
+			return fmt.Errorf("invalid pattern %q: empty path variable name", pattern)
+		}
+	}
+	return nil
+}
+
 // NewAPIPath creates an APIPath object from a path string, in the format
-// GET/users/{uuid}.
+// GET/users/{uuid}. Path variables can specify a type, as in {id:int}; the
+// supported types are int, float, and uuid.
 func NewAPIPath(path string) (*APIPath, error) {
 	parts := strings.Split(path, "/")
 	// path must have at least a method and one slash
 	if len(parts) < 2 {
 		return nil, fmt.Errorf("invalid path: %s", path)
 	}
+	for _, part := range parts[1:] {
+		_, varType, ok := parsePathVar(part)
+		if ok && varType != "" && pathVarTypes[varType] == nil {
+			return nil, fmt.Errorf("invalid path variable type %s in path: %s", varType, path)
+		}
+	}
 	return &APIPath{
 		Method:    parts[0],
 		PathParts: parts[1:],
@@ -45,9 +201,9 @@ func (a *APIPath) Match(method, path string) (pathVars PathVars, ok bool) {
 	pathVars = make(map[string]string)
 	for i, p := range parts {
 		apiPart := a.PathParts[i]
-		if len(apiPart) > 1 && apiPart[0] == '{' && apiPart[len(apiPart)-1] == '}' {
+		if name, _, isPathVar := parsePathVar(apiPart); isPathVar {
 			// This path part is a path variable
-			pathVars[apiPart[1:len(apiPart)-1]] = p
+			pathVars[name] = p
 		} else if p != apiPart {
 			// If not a path variable, and they don't match, this path is incorrect
 			return nil, false
@@ -69,10 +225,26 @@ func (a *APIPath) MatchPath(path string) bool {
 
 	for i, p := range parts {
 		apiPart := a.PathParts[i]
-		isPathVar := len(apiPart) > 1 && apiPart[0] == '{' && apiPart[len(apiPart)-1] == '}'
+		_, _, isPathVar := parsePathVar(apiPart)
 		if !isPathVar && p != apiPart {
 			return false
 		}
 	}
 	return true
 }
+
+// ValidateVars checks that each typed path variable in pathVars can be parsed
+// as its declared type. Since the request path is well formed but its values
+// are not, the returned error has status 422 Unprocessable Entity.
+func (a *APIPath) ValidateVars(pathVars PathVars) error {
+	for _, part := range a.PathParts {
+		name, varType, ok := parsePathVar(part)
+		if !ok || varType == "" {
+			continue
+		}
+		if err := pathVarTypes[varType](pathVars, name); err != nil {
+			return NewAPIError(http.StatusUnprocessableEntity, err.Error())
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package dispatch_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/flick-web/dispatch"
@@ -63,3 +64,83 @@ func TestPathVariables(t *testing.T) {
 		t.Errorf("Incorrect path var %s", pathVars["foo"])
 	}
 }
+
+func TestPathVarGetters(t *testing.T) {
+	pathVars := dispatch.PathVars{
+		"id":    "42",
+		"ratio": "0.5",
+		"uuid":  "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"bad":   "abc",
+	}
+
+	if i, err := pathVars.GetInt("id"); i != 42 || err != nil {
+		t.Errorf("GetInt returned %d, %v", i, err)
+	}
+	if i, err := pathVars.GetInt64("id"); i != 42 || err != nil {
+		t.Errorf("GetInt64 returned %d, %v", i, err)
+	}
+	if f, err := pathVars.GetFloat("ratio"); f != 0.5 || err != nil {
+		t.Errorf("GetFloat returned %f, %v", f, err)
+	}
+	if id, err := pathVars.GetUUID("uuid"); id[0] != 0x6b || err != nil {
+		t.Errorf("GetUUID returned %x, %v", id, err)
+	}
+
+	if _, err := pathVars.GetInt("bad"); err == nil {
+		t.Error("Expected error for invalid integer")
+	}
+	if _, err := pathVars.GetUUID("bad"); err == nil {
+		t.Error("Expected error for invalid UUID")
+	}
+	if _, err := pathVars.GetInt("missing"); err == nil {
+		t.Error("Expected error for missing variable")
+	}
+	if s, ok := pathVars.String("id"); s != "42" || !ok {
+		t.Errorf("String returned %q, %v", s, ok)
+	}
+	if _, ok := pathVars.String("missing"); ok {
+		t.Error("Expected String to report missing variable")
+	}
+	if s := pathVars.MustString("bad"); s != "abc" {
+		t.Errorf("MustString returned %q", s)
+	}
+}
+
+func TestPathVarMustStringPanics(t *testing.T) {
+	defer func() {
+		r := recover()
+		if err, ok := r.(error); !ok || !strings.Contains(err.Error(), "missing") {
+			t.Errorf("Expected panic naming the variable, got %v", r)
+		}
+	}()
+	dispatch.PathVars{}.MustString("missing")
+}
+
+func TestTypedPathVariables(t *testing.T) {
+	_, err := dispatch.NewAPIPath("GET/items/{id:bogus}")
+	if err == nil {
+		t.Error("Expected error for unknown path variable type")
+	}
+
+	apiPath, err := dispatch.NewAPIPath("GET/items/{id:int}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pathVars, match := apiPath.Match("GET", "/items/12")
+	if !match || pathVars["id"] != "12" {
+		t.Errorf("Unexpected match %v %v", match, pathVars)
+	}
+	if err := apiPath.ValidateVars(pathVars); err != nil {
+		t.Error(err)
+	}
+
+	pathVars, match = apiPath.Match("GET", "/items/abc")
+	if !match {
+		t.Error("match should have been true")
+	}
+	err = apiPath.ValidateVars(pathVars)
+	if apiErr, ok := err.(*dispatch.APIError); !ok || apiErr.StatusCode != 422 {
+		t.Errorf("Expected 422 error, got %v", err)
+	}
+}
@@ -1,13 +1,15 @@
 package dispatch
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
+	"encoding/base64"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
-	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-lambda-go/events"
 )
@@ -19,56 +21,125 @@ import (
 //	log.Fatal(http.ListenAndServe(":8000", nil))
 //
 // The provided handler takes care of access control headers, CORS requests,
-// JSON marshalling, and error handling.
+// JSON marshalling, and error handling. The context of each call is derived
+// from the request's context, so it is cancelled when the client goes away.
 func (api *API) HTTPProxy(w http.ResponseWriter, r *http.Request) {
-	wroteHeader := 200
-	wroteStatus := http.StatusText(200)
-	startTime := time.Now()
-	defer func() {
-		fmt.Printf("%v %s%s - %d %s\n", time.Since(startTime), r.Method, r.URL.Path, wroteHeader, wroteStatus)
-	}()
-	writeError := func(w http.ResponseWriter, error string, code int) {
-		wroteHeader = code
-		wroteStatus = http.StatusText(code)
-		http.Error(w, error, code)
-	}
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	// w.Header().Set("Access-Control-Allow-Methods", "PUT, POST, GET, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	setCORSHeaders(w)
 	if r.Method == "OPTIONS" {
-		validMethods := api.GetMethodsForPath(r.URL.Path)
-		w.Header().Set("Access-Control-Allow-Methods", strings.Join(validMethods, ", "))
-		w.WriteHeader(200)
+		writeOptions(w, api, r)
 		return
 	}
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// Restore the body for handlers registered with API.Handle
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
 	// TODO: Limit each call with timeout
-	ctx := context.Background()
+	ctx := SetContextHTTPRequest(r.Context(), r)
+	ctx = SetContextHTTPResponseWriter(ctx, w)
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		ctx = SetContextRequestID(ctx, id)
+	}
+	ctx = withProxyState(ctx)
 	output, err := api.Call(ctx, r.Method, r.URL.Path, data)
+	applyCORSPolicy(ctx, w.Header(), r.Header.Get("Origin"))
 	if err != nil {
-		switch err {
-		case ErrNotFound:
-			writeError(w, err.Error(), http.StatusNotFound)
-		case ErrBadRequest:
-			writeError(w, err.Error(), http.StatusBadRequest)
-		default:
-			writeError(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), ErrorStatusCode(err))
+		return
+	}
+	if _, ok := output.(responseWritten); ok {
+		return
+	}
+	if resp, ok := output.(*http.Response); ok && resp != nil {
+		writeUpstreamResponse(w, resp)
+		return
+	}
+	if resp, ok := output.(*Response); ok && resp != nil {
+		for key, value := range resp.Headers {
+			w.Header().Set(key, value)
+		}
+		body, encoded := takeEncodedBody(ctx, w.Header())
+		if !encoded {
+			body, err = resp.encode(api, w.Header())
 		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(resp.statusCode())
+		w.Write(body)
 		return
 	}
-	outBytes, err := json.Marshal(output)
+	outBytes, encoded := takeEncodedBody(ctx, w.Header())
+	if !encoded {
+		outBytes, err = api.marshalBody(w.Header(), output)
+	}
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
 	w.Write(outBytes)
 }
 
+// Handler returns an http.Handler that serves the API with HTTPProxy.
+func (api *API) Handler() http.Handler {
+	return http.HandlerFunc(api.HTTPProxy)
+}
+
+// setCORSHeaders sets the access control headers sent by HTTPProxy.
+func setCORSHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// w.Header().Set("Access-Control-Allow-Methods", "PUT, POST, GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+}
+
+// writeOptions responds to an OPTIONS request with the methods that the API
+// allows for its path, applying the CORS policy of the endpoint it is for.
+func writeOptions(w http.ResponseWriter, api *API, r *http.Request) {
+	methods := AllowedMethods(api, r.URL.Path)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if policy := preflightPolicy(api, r.Header.Get("Access-Control-Request-Method"), r.URL.Path); policy != nil {
+		policy.applyPreflight(w.Header(), r.Header.Get("Origin"), methods)
+	}
+	w.WriteHeader(200)
+}
+
+// AllowedMethods returns the methods that api has endpoints for at path. It is
+// the same as api.GetMethodsForPath.
+func AllowedMethods(api *API, path string) []string {
+	return api.GetMethodsForPath(path)
+}
+
+// OptionsHandler returns an http.Handler that responds to OPTIONS requests
+// with the same access control headers as HTTPProxy, listing the methods that
+// api allows for the request path. It is useful with routers that handle
+// OPTIONS requests separately from other methods. Other methods are rejected
+// with status 405.
+func OptionsHandler(api *API) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "OPTIONS" {
+			w.Header().Set("Allow", "OPTIONS")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		setCORSHeaders(w)
+		writeOptions(w, api, r)
+	})
+}
+
+// writeUpstreamResponse copies the status code, headers, and body of an
+// upstream response returned by a handler to w.
+func writeUpstreamResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
 // LambdaProxy returns a handler function suitable for use with github.com/aws/aws-lambda-go/lambda.
 // For example:
 //
@@ -78,16 +149,13 @@ func (api *API) HTTPProxy(w http.ResponseWriter, r *http.Request) {
 //	}
 //
 // The provided handler takes care of access control headers, CORS requests,
-// JSON marshalling, and error handling.
+// JSON marshalling, and error handling. Base64-encoded request bodies are
+// decoded before they are passed to the API.
 func (api *API) LambdaProxy(corsAllowedOrigin string) func(*events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
 	return func(apr *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
 		response := &events.APIGatewayProxyResponse{
 			Headers: make(map[string]string),
 		}
-		startTime := time.Now()
-		defer func() {
-			fmt.Printf("%v %s%s - %d\n", time.Since(startTime), apr.HTTPMethod, apr.Path, response.StatusCode)
-		}()
 		writeError := func(err string, code int) {
 			response.Body = err
 			response.StatusCode = code
@@ -99,44 +167,147 @@ func (api *API) LambdaProxy(corsAllowedOrigin string) func(*events.APIGatewayPro
 		if apr.HTTPMethod == "OPTIONS" {
 			validMethods := api.GetMethodsForPath(apr.Path)
 			response.Headers["Access-Control-Allow-Methods"] = strings.Join(validMethods, ", ")
+			headers := lambdaHeaders(apr)
+			if policy := preflightPolicy(api, headers.Get("Access-Control-Request-Method"), apr.Path); policy != nil {
+				policy.applyPreflight(lambdaHeaderMap(response.Headers), headers.Get("Origin"), validMethods)
+			}
 			response.StatusCode = http.StatusOK
 			return response, nil
 		}
 
 		data := []byte(apr.Body)
+		if apr.IsBase64Encoded {
+			var err error
+			if data, err = base64.StdEncoding.DecodeString(apr.Body); err != nil {
+				writeError(err.Error(), http.StatusBadRequest)
+				return response, nil
+			}
+		}
 
 		// TODO: Limit each call with timeout
 		ctx := context.Background()
 		ctx = SetContextLambdaRequest(ctx, apr)
 		ctx = SetContextLambdaResponse(ctx, response)
+		ctx = SetContextRequestID(ctx, apr.RequestContext.RequestID)
+		ctx = withProxyState(ctx)
 		output, err := api.Call(ctx, apr.HTTPMethod, apr.Path, data)
+		applyCORSPolicy(ctx, lambdaHeaderMap(response.Headers), lambdaHeaders(apr).Get("Origin"))
 		if err != nil {
-			if apiErr, ok := err.(*APIError); ok {
-				writeError(apiErr.Error(), apiErr.StatusCode)
+			writeError(err.Error(), ErrorStatusCode(err))
+			return response, nil
+		}
+		if resp, ok := output.(*http.Response); ok && resp != nil {
+			err = copyUpstreamResponse(response, resp)
+			if err != nil {
+				writeError(err.Error(), http.StatusBadGateway)
+			}
+			return response, nil
+		}
+		if resp, ok := output.(*Response); ok && resp != nil {
+			for key, value := range resp.Headers {
+				response.Headers[key] = value
+			}
+			if body, ok := takeEncodedBody(ctx, lambdaHeaderMap(response.Headers)); ok {
+				setLambdaBinaryBody(response, body)
+				response.StatusCode = resp.statusCode()
 				return response, nil
 			}
-			switch err {
-			case ErrNotFound:
-				writeError(err.Error(), http.StatusNotFound)
-			case ErrBadRequest:
-				writeError(err.Error(), http.StatusBadRequest)
-			default:
+			body, err := resp.encode(api, lambdaHeaderMap(response.Headers))
+			if err != nil {
 				writeError(err.Error(), http.StatusInternalServerError)
+				return response, nil
 			}
+			response.Body = string(body)
+			response.StatusCode = resp.statusCode()
 			return response, nil
 		}
-		outBytes, err := json.Marshal(output)
+		if body, ok := takeEncodedBody(ctx, lambdaHeaderMap(response.Headers)); ok {
+			setLambdaBinaryBody(response, body)
+			response.StatusCode = http.StatusOK
+			return response, nil
+		}
+		outBytes, err := api.marshalBody(lambdaHeaderMap(response.Headers), output)
 		if err != nil {
 			writeError(err.Error(), http.StatusInternalServerError)
 			return response, nil
 		}
-		response.Headers["Content-Type"] = "application/json"
 		response.Body = string(outBytes)
 		response.StatusCode = http.StatusOK
 		return response, nil
 	}
 }
 
+// lambdaHeaderMap adapts the headers of an API Gateway response for use with
+// API.marshalBody and CORS policies.
+type lambdaHeaderMap map[string]string
+
+func (h lambdaHeaderMap) Get(key string) string {
+	return h[key]
+}
+
+func (h lambdaHeaderMap) Set(key, value string) {
+	h[key] = value
+}
+
+func (h lambdaHeaderMap) Del(key string) {
+	delete(h, key)
+}
+
+// lambdaHTTPRequest converts an API Gateway proxy request to an *http.Request.
+func lambdaHTTPRequest(ctx context.Context, apr *events.APIGatewayProxyRequest) (*http.Request, error) {
+	body := []byte(apr.Body)
+	if apr.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(apr.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	target := &url.URL{Path: apr.Path, RawQuery: lambdaQuery(apr).Encode()}
+	r, err := http.NewRequestWithContext(ctx, apr.HTTPMethod, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header = lambdaHeaders(apr)
+	r.RemoteAddr = apr.RequestContext.Identity.SourceIP
+	return r, nil
+}
+
+// copyUpstreamResponse copies the status code, headers, and body of an
+// upstream response returned by a handler into an API Gateway response. Bodies
+// that are not valid UTF-8 are base64 encoded.
+func copyUpstreamResponse(response *events.APIGatewayProxyResponse, resp *http.Response) error {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	for key, values := range resp.Header {
+		if len(values) == 1 {
+			response.Headers[key] = values[0]
+			continue
+		}
+		if response.MultiValueHeaders == nil {
+			response.MultiValueHeaders = make(map[string][]string)
+		}
+		response.MultiValueHeaders[key] = values
+	}
+	if utf8.Valid(body) {
+		response.Body = string(body)
+	} else {
+		setLambdaBinaryBody(response, body)
+	}
+	response.StatusCode = resp.StatusCode
+	return nil
+}
+
+// setLambdaBinaryBody sets the body of an API Gateway response to base64
+// encoded binary data.
+func setLambdaBinaryBody(response *events.APIGatewayProxyResponse, body []byte) {
+	response.Body = base64.StdEncoding.EncodeToString(body)
+	response.IsBase64Encoded = true
+}
+
 // APIGatewayUserID returns the subject from the proxy request's authorizer.
 func APIGatewayUserID(ctx events.APIGatewayProxyRequestContext) string {
 	if ctx.Authorizer == nil {
@@ -0,0 +1,259 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func testUpstreamHandler() (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"X-Upstream": []string{"yes"}},
+		Body:       ioutil.NopCloser(strings.NewReader("upstream body")),
+	}, nil
+}
+
+func TestHTTPProxyUpstreamResponse(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/upstream", testUpstreamHandler)
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/upstream", nil))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", rec.Code)
+	}
+	if rec.Header().Get("X-Upstream") != "yes" {
+		t.Error("Upstream header was not copied")
+	}
+	if rec.Body.String() != "upstream body" {
+		t.Errorf("Unexpected body %q", rec.Body.String())
+	}
+}
+
+func TestLambdaProxyUpstreamResponse(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/upstream", testUpstreamHandler)
+
+	res, err := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/upstream"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated || res.Body != "upstream body" || res.Headers["X-Upstream"] != "yes" {
+		t.Errorf("Unexpected response %+v", res)
+	}
+}
+
+var testHTTPHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(r.URL.Query().Get("name") + ":" + string(body)))
+})
+
+func TestHTTPProxyHandle(t *testing.T) {
+	api := API{}
+	api.Handle("POST/raw", testHTTPHandler)
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("POST", "/raw?name=x", strings.NewReader("hello")))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "x:hello" {
+		t.Errorf("Unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLambdaProxyHandle(t *testing.T) {
+	api := API{}
+	api.Handle("POST/raw", testHTTPHandler)
+
+	res, err := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{
+		HTTPMethod:            "POST",
+		Path:                  "/raw",
+		QueryStringParameters: map[string]string{"name": "x"},
+		Body:                  "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusAccepted || res.Body != "x:hello" || res.Headers["Content-Type"] != "text/plain" {
+		t.Errorf("Unexpected response %+v", res)
+	}
+}
+
+func TestHandleHookChanges(t *testing.T) {
+	api := API{}
+	rewrite := func(input *EndpointInput) (*EndpointInput, error) {
+		input.Input = append(input.Input, "!"...)
+		input.Headers.Set("X-Rewritten", "yes")
+		return input, nil
+	}
+	api.Handle("POST/raw", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + r.Header.Get("X-Rewritten") + " " + string(body)))
+	}), rewrite)
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("POST", "/raw", strings.NewReader("hello")))
+	if rec.Body.String() != "POST /raw yes hello!" {
+		t.Errorf("Unexpected response %q", rec.Body.String())
+	}
+
+	res, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{
+		HTTPMethod:      "POST",
+		Path:            "/raw",
+		Body:            base64.StdEncoding.EncodeToString([]byte("hello")),
+		IsBase64Encoded: true,
+	})
+	if res.StatusCode != http.StatusOK || res.Body != "POST /raw yes hello!" {
+		t.Errorf("Unexpected response %+v", res)
+	}
+
+	out, err := api.Call(context.Background(), "POST", "/raw", []byte("hello"))
+	resp, ok := out.(*http.Response)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected result %v %v", out, err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "POST /raw yes hello!" {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+}
+
+func testRedirectHandler(ctx context.Context) (string, error) {
+	Respond(ctx, http.StatusFound, map[string]string{"Location": "/elsewhere"}, nil)
+	return "ignored", errors.New("ignored")
+}
+
+func TestHTTPProxyRespond(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/redirect", testRedirectHandler)
+	api.AddEndpoint("GET/created", func() *Response {
+		return &Response{StatusCode: http.StatusCreated, Body: map[string]int{"id": 1}}
+	})
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/redirect", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/elsewhere" || rec.Body.Len() != 0 {
+		t.Errorf("Unexpected response %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/created", nil))
+	if rec.Code != http.StatusCreated || rec.Body.String() != `{"id":1}` {
+		t.Errorf("Unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLambdaProxyRespond(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/redirect", testRedirectHandler)
+
+	res, err := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/redirect"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusFound || res.Headers["Location"] != "/elsewhere" || res.Body != "" {
+		t.Errorf("Unexpected response %+v", res)
+	}
+}
+
+func TestRawJSONTypes(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/raw", func() json.RawMessage { return json.RawMessage(`{"raw": true}`) })
+	api.AddEndpoint("GET/vendor", func(ctx context.Context) json.RawMessage {
+		ContextHTTPResponseWriter(ctx).Header().Set("Content-Type", "application/vnd.api+json")
+		return json.RawMessage(`{"raw": true}`)
+	})
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/raw", nil))
+	if rec.Body.String() != `{"raw": true}` || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected raw body, got %q", rec.Body.String())
+	}
+
+	// Not a raw JSON type, so it is marshalled (and compacted) as usual
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/vendor", nil))
+	if rec.Body.String() != `{"raw":true}` {
+		t.Errorf("Expected marshalled body, got %q", rec.Body.String())
+	}
+
+	api.RawJSONTypes = []string{"application/vnd.api+json"}
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/vendor", nil))
+	if rec.Body.String() != `{"raw": true}` {
+		t.Errorf("Expected raw body, got %q", rec.Body.String())
+	}
+}
+
+func TestOptionsHandler(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET,PUT/items/{id}", func() {})
+	handler := OptionsHandler(&api)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/items/1", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Methods") != "GET, PUT" {
+		t.Errorf("Unexpected response %d %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/items/1", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestDeprecatedEndpoint(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/old", func() {})
+	endpoint, _ := api.MatchEndpoint("GET", "/old")
+	endpoint.Deprecated = true
+	endpoint.DeprecationDate = time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	endpoint.SunsetURL = "https://example.com/migrate"
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/old", nil))
+	if rec.Header().Get("Deprecation") != "true" ||
+		rec.Header().Get("Sunset") != "Wed, 02 Jan 2030 03:04:05 GMT" ||
+		rec.Header().Get("Link") != `<https://example.com/migrate>; rel="sunset"` {
+		t.Errorf("Unexpected headers %v", rec.Header())
+	}
+
+	res, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/old"})
+	if res.Headers["Deprecation"] != "true" {
+		t.Errorf("Unexpected headers %v", res.Headers)
+	}
+}
+
+func TestHTTPProxyNestedCall(t *testing.T) {
+	api := &API{}
+	api.AddEndpoint("GET/inner", func() string { return "inner" }, After(NewGzipHook()))
+	var endpoint *Endpoint
+	api.AddEndpoint("GET/outer", func(ctx context.Context) (interface{}, error) {
+		return api.Call(ctx, "GET", "/inner", nil)
+	}, After(func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		endpoint = ContextEndpoint(input.Ctx)
+		return out, err
+	}))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/outer", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	api.HTTPProxy(rec, req)
+	if rec.Body.String() != `"inner"` || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected the outer call's uncompressed body, got %q %v", rec.Body.String(), rec.Header())
+	}
+	if endpoint == nil || endpoint.Path != "GET/outer" {
+		t.Errorf("Expected the outer endpoint in the outer call, got %v", endpoint)
+	}
+}
@@ -0,0 +1,236 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A RateLimitStore tracks request rates by key, allowing a sustained rate of
+// rps requests per second with bursts of up to burst requests. Allow reports
+// whether a request with key is allowed and, if not, how long to wait before
+// retrying. Implementations backed by a shared store such as Redis or DynamoDB
+// allow rate limits to be enforced across several instances of an API, and
+// should give up when ctx, the context of the request, is done.
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string, rps float64, burst int) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// localRateLimitStore is a RateLimitStore that keeps a token bucket for each
+// key in memory.
+type localRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled completely at its own rate.
+	full time.Time
+}
+
+// newLocalRateLimitStore returns an in-memory RateLimitStore, which only limits
+// the requests handled by this process.
+func newLocalRateLimitStore() *localRateLimitStore {
+	return &localRateLimitStore{buckets: make(map[string]*tokenBucket), lastPrune: time.Now()}
+}
+
+func (s *localRateLimitStore) Allow(ctx context.Context, key string, rps float64, burst int) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.prune(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rps)
+	bucket.last = now
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	bucket.full = now.Add(time.Duration((float64(burst) - bucket.tokens) / rps * float64(time.Second)))
+	if allowed {
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - bucket.tokens) / rps * float64(time.Second))
+	return false, wait, nil
+}
+
+// prune removes buckets that have been idle long enough to refill completely,
+// at most once a minute, so that the store does not grow without bound.
+func (s *localRateLimitStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+	for key, bucket := range s.buckets {
+		if now.After(bucket.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// NewRateLimitHook returns a middleware hook that limits requests with the same
+// key to a sustained rate of rps requests per second, with bursts of up to
+// burst requests. If key is nil, requests are limited by client IP address.
+// Requests over the limit fail with status 429, with a Retry-After header.
+//
+// If store is nil, limits are tracked in memory, and only apply to the
+// requests handled by this process. Errors from store are logged, and the
+// request is allowed.
+//
+// NewRateLimitHook returns an error if rps is not positive or burst is less
+// than 1.
+func NewRateLimitHook(rps float64, burst int, key func(*EndpointInput) string, store RateLimitStore) (MiddlewareHook, error) {
+	if err := validateRateLimit(rps, burst); err != nil {
+		return nil, err
+	}
+	if key == nil {
+		key = func(input *EndpointInput) string { return input.RemoteAddr }
+	}
+	if store == nil {
+		store = newLocalRateLimitStore()
+	}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		return checkRateLimit(input, store, key(input), rps, burst)
+	}, nil
+}
+
+// MustNewRateLimitHook is like NewRateLimitHook, but panics if rps or burst is
+// invalid.
+func MustNewRateLimitHook(rps float64, burst int, key func(*EndpointInput) string, store RateLimitStore) MiddlewareHook {
+	hook, err := NewRateLimitHook(rps, burst, key, store)
+	if err != nil {
+		panic("dispatch: " + err.Error())
+	}
+	return hook
+}
+
+// validateRateLimit returns an error if rps or burst cannot be used for a
+// token bucket.
+func validateRateLimit(rps float64, burst int) error {
+	if rps <= 0 {
+		return fmt.Errorf("invalid rate limit: %v requests per second", rps)
+	}
+	if burst < 1 {
+		return fmt.Errorf("invalid rate limit burst: %d", burst)
+	}
+	return nil
+}
+
+// checkRateLimit fails the request if key is over its rate limit in store.
+func checkRateLimit(input *EndpointInput, store RateLimitStore, key string, rps float64, burst int) (*EndpointInput, error) {
+	allowed, retryAfter, err := store.Allow(input.Ctx, key, rps, burst)
+	if err != nil {
+		contextLogger(input.Ctx).Printf("Rate limit store error for %s %s: %v\n", input.Method, input.Path, err)
+		return input, nil
+	}
+	if !allowed {
+		setRetryAfter(input.Ctx, retryAfter)
+		return nil, NewAPIError(http.StatusTooManyRequests, "rate limit exceeded")
+	}
+	return input, nil
+}
+
+// NewIPRateLimitHook returns a middleware hook that limits requests from each
+// client IP address, as NewRateLimitHook does with an in-memory store. Set
+// API.TrustProxy for the client address to be read from the X-Forwarded-For or
+// X-Real-IP headers when the API is behind a load balancer. It returns an error
+// if rps or burst is invalid, as NewRateLimitHook does.
+func NewIPRateLimitHook(rps float64, burst int) (MiddlewareHook, error) {
+	return NewRateLimitHook(rps, burst, func(input *EndpointInput) string {
+		return input.RemoteAddr
+	}, nil)
+}
+
+// NewUserRateLimitHook returns a middleware hook that limits requests from each
+// user, identified by the sub claim of the JWT claims in the context, as
+// NewRateLimitHook does with an in-memory store. Requests without a sub claim,
+// such as those to unauthenticated endpoints, are limited by client IP address
+// instead. The hook must be added after the authentication hook that stores
+// the claims. It returns an error if rps or burst is invalid, as
+// NewRateLimitHook does.
+func NewUserRateLimitHook(rps float64, burst int) (MiddlewareHook, error) {
+	return NewRateLimitHook(rps, burst, func(input *EndpointInput) string {
+		if sub, _ := ContextJWTClaims(input.Ctx)["sub"].(string); sub != "" {
+			return "user:" + sub
+		}
+		return "ip:" + input.RemoteAddr
+	}, nil)
+}
+
+// MustNewIPRateLimitHook is like NewIPRateLimitHook, but panics if rps or burst
+// is invalid.
+func MustNewIPRateLimitHook(rps float64, burst int) MiddlewareHook {
+	hook, err := NewIPRateLimitHook(rps, burst)
+	if err != nil {
+		panic("dispatch: " + err.Error())
+	}
+	return hook
+}
+
+// MustNewUserRateLimitHook is like NewUserRateLimitHook, but panics if rps or
+// burst is invalid.
+func MustNewUserRateLimitHook(rps float64, burst int) MiddlewareHook {
+	hook, err := NewUserRateLimitHook(rps, burst)
+	if err != nil {
+		panic("dispatch: " + err.Error())
+	}
+	return hook
+}
+
+// defaultAPIKeyRate is the rate, in requests per second, allowed by
+// NewAPIKeyRateLimitHook for API key owners without a configured limit.
+const defaultAPIKeyRate = 1
+
+// NewAPIKeyRateLimitHook returns a middleware hook that limits requests from
+// each API key owner, as stored by NewAPIKeyHook, to the rate in requests per
+// second given for the owner in limits. This allows tiered limits, such as for
+// free and paid plans. Owners not in limits are allowed 1 request per second.
+// Requests without an API key owner are limited by client IP address instead,
+// each at the rate given for the empty owner, or 1 request per second. Each
+// owner may make bursts of up to a second's worth of requests. The hook must
+// be added after the API key hook.
+//
+// NewAPIKeyRateLimitHook returns an error if any rate in limits is not
+// positive.
+func NewAPIKeyRateLimitHook(limits map[string]float64) (MiddlewareHook, error) {
+	for owner, rps := range limits {
+		if rps <= 0 {
+			return nil, fmt.Errorf("invalid rate limit for %q: %v requests per second", owner, rps)
+		}
+	}
+	store := newLocalRateLimitStore()
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		owner := ContextAPIKeyOwner(input.Ctx)
+		rps, ok := limits[owner]
+		if !ok {
+			rps = defaultAPIKeyRate
+		}
+		key := "owner:" + owner
+		if owner == "" {
+			key = "ip:" + input.RemoteAddr
+		}
+		burst := int(math.Ceil(rps))
+		return checkRateLimit(input, store, key, rps, burst)
+	}, nil
+}
+
+// MustNewAPIKeyRateLimitHook is like NewAPIKeyRateLimitHook, but panics if any
+// rate in limits is not positive.
+func MustNewAPIKeyRateLimitHook(limits map[string]float64) MiddlewareHook {
+	hook, err := NewAPIKeyRateLimitHook(limits)
+	if err != nil {
+		panic("dispatch: " + err.Error())
+	}
+	return hook
+}
@@ -0,0 +1,217 @@
+package dispatch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRateLimitStore is a RateLimitStore that records the keys it is asked
+// about and allows a fixed number of requests.
+type testRateLimitStore struct {
+	keys      []string
+	remaining int
+	err       error
+}
+
+func (s *testRateLimitStore) Allow(ctx context.Context, key string, rps float64, burst int) (bool, time.Duration, error) {
+	s.keys = append(s.keys, key)
+	if s.err != nil {
+		return false, 0, s.err
+	}
+	if s.remaining == 0 {
+		return false, 1500 * time.Millisecond, nil
+	}
+	s.remaining--
+	return true, 0, nil
+}
+
+func TestRateLimitHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/limited", func() {}, MustNewRateLimitHook(1, 2, nil, nil))
+
+	codes := make([]int, 3)
+	for i := range codes {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("GET", "/limited", nil))
+		codes[i] = rec.Code
+		if i == 2 && rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("Unexpected Retry-After %q", rec.Header().Get("Retry-After"))
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("Expected burst of 2 to be allowed, got %v", codes)
+	}
+
+	// Requests from other clients have their own limit
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/limited", nil)
+	req.RemoteAddr = "192.0.2.99:1234"
+	api.HTTPProxy(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected other client to be allowed, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitHookStore(t *testing.T) {
+	store := &testRateLimitStore{remaining: 1}
+	key := func(input *EndpointInput) string { return input.Headers.Get("X-Client") }
+	logger := &testLogger{}
+	api := API{Logger: logger}
+	api.AddEndpoint("GET/limited", func() {}, MustNewRateLimitHook(10, 10, key, store))
+
+	request := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/limited", nil)
+		req.Header.Set("X-Client", "c1")
+		api.HTTPProxy(rec, req)
+		return rec
+	}
+	if rec := request(); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+	if rec := request(); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 429 with Retry-After 2, got %d %v", rec.Code, rec.Header())
+	}
+	store.err = errTemporary
+	if rec := request(); rec.Code != http.StatusOK {
+		t.Errorf("Expected store errors to allow the request, got %d", rec.Code)
+	}
+	if !strings.Contains(logger.String(), "Rate limit store error for GET /limited") {
+		t.Errorf("Expected store error to be logged, got %q", logger.String())
+	}
+	if len(store.keys) != 3 || store.keys[0] != "c1" {
+		t.Errorf("Unexpected keys %v", store.keys)
+	}
+}
+
+func TestIPRateLimitHookTrustProxy(t *testing.T) {
+	for _, trustProxy := range []bool{false, true} {
+		api := API{TrustProxy: trustProxy}
+		api.AddEndpoint("GET/limited", func() {}, MustNewIPRateLimitHook(1, 1))
+
+		allowed := 0
+		for _, client := range []string{"198.51.100.1", "198.51.100.2, 10.0.0.1"} {
+			req := httptest.NewRequest("GET", "/limited", nil)
+			req.Header.Set("X-Forwarded-For", client)
+			rec := httptest.NewRecorder()
+			api.HTTPProxy(rec, req)
+			if rec.Code == http.StatusOK {
+				allowed++
+			}
+		}
+		// Clients behind a trusted proxy are limited separately
+		if expected := map[bool]int{false: 1, true: 2}[trustProxy]; allowed != expected {
+			t.Errorf("TrustProxy %v: expected %d allowed, got %d", trustProxy, expected, allowed)
+		}
+	}
+}
+
+func TestUserRateLimitHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/limited", func() {}, MustNewUserRateLimitHook(1, 1))
+
+	call := func(claims JWTClaims) error {
+		ctx := SetContextJWTClaims(context.Background(), claims)
+		_, err := api.Call(ctx, "GET", "/limited", nil)
+		return err
+	}
+	if err := call(JWTClaims{"sub": "u1"}); err != nil {
+		t.Error(err)
+	}
+	if err := call(JWTClaims{"sub": "u1"}); ErrorStatusCode(err) != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for the same user, got %v", err)
+	}
+	if err := call(JWTClaims{"sub": "u2"}); err != nil {
+		t.Errorf("Expected other user to be allowed, got %v", err)
+	}
+	if err := call(JWTClaims{}); err != nil {
+		t.Errorf("Expected anonymous request to be limited by IP, got %v", err)
+	}
+}
+
+func TestAPIKeyRateLimitHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/limited", func() {}, MustNewAPIKeyRateLimitHook(map[string]float64{"pro": 3}))
+
+	allowed := func(owner string) int {
+		n := 0
+		for i := 0; i < 5; i++ {
+			ctx := SetContextAPIKeyOwner(context.Background(), owner)
+			if _, err := api.Call(ctx, "GET", "/limited", nil); err == nil {
+				n++
+			}
+		}
+		return n
+	}
+	if n := allowed("pro"); n != 3 {
+		t.Errorf("Expected 3 requests for pro owner, got %d", n)
+	}
+	if n := allowed("free"); n != 1 {
+		t.Errorf("Expected default of 1 request for unknown owner, got %d", n)
+	}
+
+	anonymous := func(addr string) error {
+		req := httptest.NewRequest("GET", "/limited", nil)
+		req.RemoteAddr = addr + ":1234"
+		_, err := api.Call(SetContextHTTPRequest(context.Background(), req), "GET", "/limited", nil)
+		return err
+	}
+	if err := anonymous("192.0.2.1"); err != nil {
+		t.Errorf("Expected first anonymous request to be allowed, got %v", err)
+	}
+	if err := anonymous("192.0.2.2"); err != nil {
+		t.Errorf("Expected anonymous clients to be limited separately, got %v", err)
+	}
+	if err := anonymous("192.0.2.1"); ErrorStatusCode(err) != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for the same anonymous client, got %v", err)
+	}
+}
+
+func TestRateLimitHookInvalid(t *testing.T) {
+	for _, limit := range []struct {
+		rps   float64
+		burst int
+	}{{0, 1}, {-1, 1}, {1, 0}} {
+		if _, err := NewRateLimitHook(limit.rps, limit.burst, nil, nil); err == nil {
+			t.Errorf("Expected an error for rate %v and burst %d", limit.rps, limit.burst)
+		}
+		if _, err := NewIPRateLimitHook(limit.rps, limit.burst); err == nil {
+			t.Errorf("Expected an IP rate limit error for rate %v and burst %d", limit.rps, limit.burst)
+		}
+		if _, err := NewUserRateLimitHook(limit.rps, limit.burst); err == nil {
+			t.Errorf("Expected a user rate limit error for rate %v and burst %d", limit.rps, limit.burst)
+		}
+	}
+}
+
+func TestAPIKeyRateLimitHookInvalid(t *testing.T) {
+	for _, rps := range []float64{0, -1} {
+		if _, err := NewAPIKeyRateLimitHook(map[string]float64{"pro": rps}); err == nil {
+			t.Errorf("Expected an error for rate %v", rps)
+		}
+	}
+}
+
+func TestLocalRateLimitStorePrune(t *testing.T) {
+	store := newLocalRateLimitStore()
+	store.Allow(context.Background(), "low", 0.01, 1)
+	store.Allow(context.Background(), "idle", 1000, 1000)
+	for _, bucket := range store.buckets {
+		bucket.last = bucket.last.Add(-10 * time.Second)
+		bucket.full = bucket.full.Add(-10 * time.Second)
+	}
+
+	// A request at a high rate must not prune buckets that refill more slowly.
+	store.lastPrune = time.Now().Add(-2 * time.Minute)
+	store.Allow(context.Background(), "high", 1000, 1000)
+	if _, ok := store.buckets["low"]; !ok {
+		t.Error("Expected the low-rate bucket to be kept until it refills")
+	}
+	if _, ok := store.buckets["idle"]; ok {
+		t.Error("Expected the refilled bucket to be pruned")
+	}
+}
@@ -0,0 +1,138 @@
+package dispatch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// newEndpointInput creates the input passed to an endpoint's middleware hooks,
+// filling in request metadata from the HTTP or Lambda request in ctx, if any.
+func (api *API) newEndpointInput(ctx context.Context, method, path string, input []byte) *EndpointInput {
+	headers := requestHeaders(ctx)
+	return &EndpointInput{
+		Method:      method,
+		Path:        path,
+		Ctx:         ctx,
+		Input:       input,
+		Headers:     headers,
+		RemoteAddr:  remoteAddr(ctx, headers, api.trustedProxies()),
+		QueryParams: queryParams(ctx),
+		Cookies:     requestCookies(headers),
+	}
+}
+
+// requestCookies returns the values of the cookies sent in the Cookie headers.
+func requestCookies(headers http.Header) map[string]string {
+	cookies := make(map[string]string)
+	for _, cookie := range (&http.Request{Header: headers}).Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	return cookies
+}
+
+// remoteAddr returns the IP address of the client that sent the request that
+// ctx originated from. If proxies is greater than zero, the request is taken to
+// have come through that many trusted proxies, and the address is taken from
+// the X-Forwarded-For or X-Real-IP headers, if present.
+func remoteAddr(ctx context.Context, headers http.Header, proxies int) string {
+	if proxies > 0 {
+		if client := forwardedClient(headers.Values("X-Forwarded-For"), proxies); client != "" {
+			return client
+		}
+		if realIP := headers.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	if r := ContextHTTPRequest(ctx); r != nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+	if apr := ContextLambdaRequest(ctx); apr != nil {
+		return apr.RequestContext.Identity.SourceIP
+	}
+	return ""
+}
+
+// forwardedClient returns the client address from X-Forwarded-For header
+// values, for a request that came through the given number of proxies. Each
+// proxy appends the address it received the request from, so the client's
+// address is that many entries from the end, and any entries before it may have
+// been set by the client. If there are fewer entries, the first one is used.
+func forwardedClient(values []string, proxies int) string {
+	var entries []string
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	if proxies > len(entries) {
+		return entries[0]
+	}
+	return entries[len(entries)-proxies]
+}
+
+// requestHeaders returns a copy of the headers of the request that ctx
+// originated from.
+func requestHeaders(ctx context.Context) http.Header {
+	if r := ContextHTTPRequest(ctx); r != nil {
+		return r.Header.Clone()
+	}
+	if apr := ContextLambdaRequest(ctx); apr != nil {
+		return lambdaHeaders(apr)
+	}
+	return http.Header{}
+}
+
+// lambdaHeaders merges the single and multi-value headers of an API Gateway
+// request, with canonicalized names.
+func lambdaHeaders(apr *events.APIGatewayProxyRequest) http.Header {
+	header := http.Header{}
+	for key, value := range apr.Headers {
+		header.Set(key, value)
+	}
+	for key, values := range apr.MultiValueHeaders {
+		header.Del(key)
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	return header
+}
+
+// queryParams returns the query parameters of the request that ctx originated
+// from.
+func queryParams(ctx context.Context) url.Values {
+	if r := ContextHTTPRequest(ctx); r != nil {
+		return r.URL.Query()
+	}
+	if apr := ContextLambdaRequest(ctx); apr != nil {
+		return lambdaQuery(apr)
+	}
+	return url.Values{}
+}
+
+// lambdaQuery merges the single and multi-value query string parameters of an
+// API Gateway request.
+func lambdaQuery(apr *events.APIGatewayProxyRequest) url.Values {
+	query := url.Values{}
+	for key, value := range apr.QueryStringParameters {
+		query.Set(key, value)
+	}
+	for key, values := range apr.MultiValueQueryStringParameters {
+		query[key] = values
+	}
+	return query
+}
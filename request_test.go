@@ -0,0 +1,30 @@
+package dispatch
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteAddrTrustProxy(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.66, 203.0.113.7")
+	ctx := SetContextHTTPRequest(context.Background(), req)
+
+	if addr := (&API{}).newEndpointInput(ctx, "GET", "/", nil).RemoteAddr; addr != "192.0.2.1" {
+		t.Errorf("Expected connection address, got %s", addr)
+	}
+	// The leftmost entry may be spoofed by the client
+	if addr := (&API{TrustProxy: true}).newEndpointInput(ctx, "GET", "/", nil).RemoteAddr; addr != "203.0.113.7" {
+		t.Errorf("Expected address added by the proxy, got %s", addr)
+	}
+	req.Header.Add("X-Forwarded-For", "10.0.0.1")
+	if addr := (&API{TrustProxy: true, TrustedProxies: 2}).newEndpointInput(ctx, "GET", "/", nil).RemoteAddr; addr != "203.0.113.7" {
+		t.Errorf("Expected address added by the outer proxy, got %s", addr)
+	}
+	req.Header.Del("X-Forwarded-For")
+	req.Header.Set("X-Real-IP", "203.0.113.8")
+	if addr := (&API{TrustProxy: true}).newEndpointInput(ctx, "GET", "/", nil).RemoteAddr; addr != "203.0.113.8" {
+		t.Errorf("Expected real IP address, got %s", addr)
+	}
+}
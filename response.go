@@ -0,0 +1,386 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Response is an endpoint result with an explicit status code and headers.
+// Handlers can return a *Response directly, or set one with Respond. Body is
+// marshalled to JSON, and if it is nil, no body is written.
+type Response struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       interface{}
+}
+
+// statusCode returns the response's status code, defaulting to 200.
+func (r *Response) statusCode() int {
+	if r.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return r.StatusCode
+}
+
+// encode marshals the response body, or returns nil if it has none.
+func (r *Response) encode(api *API, header headerSetter) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	return api.marshalBody(header, r.Body)
+}
+
+// defaultRawJSONTypes are the content types used when API.RawJSONTypes is
+// nil.
+var defaultRawJSONTypes = []string{"application/json"}
+
+// headerSetter is satisfied by http.Header and by the headers of an API Gateway
+// response.
+type headerSetter interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// addVary adds field to the Vary header, keeping the fields already listed in
+// it, so that caches key the response on all of them.
+func addVary(header headerSetter, field string) {
+	vary := header.Get("Vary")
+	for _, listed := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(listed), field) {
+			return
+		}
+	}
+	if vary != "" {
+		field = vary + ", " + field
+	}
+	header.Set("Vary", field)
+}
+
+// marshalBody encodes a response body as JSON, setting the Content-Type
+// header to application/json if the handler has not set it already.
+//
+// If body is a json.RawMessage and the content type is one of
+// API.RawJSONTypes, it is written as-is rather than marshalled again.
+func (api *API) marshalBody(header headerSetter, body interface{}) ([]byte, error) {
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	if raw, ok := body.(json.RawMessage); ok {
+		rawTypes := api.RawJSONTypes
+		if rawTypes == nil {
+			rawTypes = defaultRawJSONTypes
+		}
+		contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		for _, rawType := range rawTypes {
+			if contentType == rawType {
+				return raw, nil
+			}
+		}
+	}
+	return json.Marshal(body)
+}
+
+// Respond sets the response for the current call, bypassing the default
+// 200 OK JSON response. It can be used for redirects, custom status codes, or
+// extra headers. Once the handler returns, API.Call returns the response
+// instead of the handler's own result or error. Respond has no effect outside
+// of API.Call.
+func Respond(ctx context.Context, statusCode int, headers map[string]string, body interface{}) {
+	state := contextCallState(ctx)
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.response = &Response{
+		StatusCode: statusCode,
+		Headers:    headers,
+		Body:       body,
+	}
+}
+
+// Abort stops the current call with err. API.Call checks for an aborted call
+// before running each middleware hook and before calling the handler, so a
+// hook can abort the call from a goroutine it starts without returning the
+// error itself. Only the first error passed to Abort is kept. Abort has no
+// effect outside of API.Call.
+func Abort(ctx context.Context, err error) {
+	state := contextCallState(ctx)
+	if state == nil || err == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.abortErr == nil {
+		state.abortErr = err
+	}
+}
+
+// SetResponseHeader sets a header on the response to the current request,
+// through the response writer of HTTPProxy or the response of LambdaProxy. It
+// has no effect if the request did not come through either proxy.
+func SetResponseHeader(ctx context.Context, name, value string) {
+	if w := ContextHTTPResponseWriter(ctx); w != nil {
+		w.Header().Set(name, value)
+	}
+	if res := ContextLambdaResponse(ctx); res != nil {
+		if res.Headers == nil {
+			res.Headers = make(map[string]string)
+		}
+		res.Headers[http.CanonicalHeaderKey(name)] = value
+	}
+}
+
+// setDeprecationHeaders sets the headers for a deprecated endpoint, with an
+// optional sunset date and a link with relation type rel.
+func setDeprecationHeaders(ctx context.Context, sunset, link, rel string) {
+	SetResponseHeader(ctx, "Deprecation", "true")
+	if sunset != "" {
+		SetResponseHeader(ctx, "Sunset", sunset)
+	}
+	if link != "" {
+		SetResponseHeader(ctx, "Link", "<"+link+`>; rel="`+rel+`"`)
+	}
+}
+
+type contextCallStateKey struct{}
+
+// callState holds values that handlers and hooks set during API.Call, for the
+// call to act on once they return.
+type callState struct {
+	mu       sync.Mutex
+	api      *API
+	start    time.Time
+	endpoint *Endpoint
+	response *Response
+	abortErr error
+	wrappers []callWrapper
+	proxy    *proxyState
+}
+
+// A callWrapper wraps the remainder of a call, after the hook that added it.
+// It must call next to continue the call, and returns the call's result.
+type callWrapper func(next func() (interface{}, error)) (interface{}, error)
+
+// wrapCall adds a wrapper around the rest of the current call. It has no effect
+// outside of API.Call.
+func wrapCall(ctx context.Context, wrapper callWrapper) {
+	state := contextCallState(ctx)
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.wrappers = append(state.wrappers, wrapper)
+}
+
+// ContextEndpoint returns the endpoint matched by the current call, or nil if
+// no endpoint has been matched yet.
+func ContextEndpoint(ctx context.Context) *Endpoint {
+	state := contextCallState(ctx)
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.endpoint
+}
+
+// responseStatus returns the HTTP status code for a call's result.
+func responseStatus(out interface{}, err error) int {
+	if err != nil {
+		return ErrorStatusCode(err)
+	}
+	switch resp := out.(type) {
+	case *Response:
+		if resp != nil {
+			return resp.statusCode()
+		}
+	case *http.Response:
+		if resp != nil {
+			return resp.StatusCode
+		}
+	}
+	return http.StatusOK
+}
+
+// withCallState returns a context with a new call state. Each call gets its own
+// state, even when it is made from inside another call's handler. The first
+// call to claim the proxy state in ctx, if any, passes values to the proxy
+// through it.
+func withCallState(ctx context.Context) (context.Context, *callState) {
+	state := &callState{}
+	if proxy := contextProxyState(ctx); proxy != nil && proxy.claim() {
+		state.proxy = proxy
+	}
+	return context.WithValue(ctx, contextCallStateKey{}, state), state
+}
+
+func contextCallState(ctx context.Context) *callState {
+	if ctx == nil {
+		return nil
+	}
+	state, _ := ctx.Value(contextCallStateKey{}).(*callState)
+	return state
+}
+
+// takeResponse returns and clears the response set with Respond.
+func (s *callState) takeResponse() *Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response := s.response
+	s.response = nil
+	return response
+}
+
+// aborted returns the error passed to Abort, if any.
+func (s *callState) aborted() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.abortErr
+}
+
+// begin records the API handling the call and the time it started.
+func (s *callState) begin(api *API) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.api = api
+	s.start = time.Now()
+}
+
+// contextAPI returns the API handling the current call, or nil outside of
+// API.Call.
+func contextAPI(ctx context.Context) *API {
+	state := contextCallState(ctx)
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.api
+}
+
+// callStart returns the time the current call started, or the zero time
+// outside of API.Call.
+func callStart(ctx context.Context) time.Time {
+	state := contextCallState(ctx)
+	if state == nil {
+		return time.Time{}
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.start
+}
+
+// contextLogger returns the Logger of the API handling the current call, or the
+// standard logger outside of API.Call.
+func contextLogger(ctx context.Context) Logger {
+	return contextAPI(ctx).logger()
+}
+
+func (s *callState) setEndpoint(endpoint *Endpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endpoint = endpoint
+}
+
+// takeWrappers returns and clears the wrappers added since the last call.
+func (s *callState) takeWrappers() []callWrapper {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wrappers := s.wrappers
+	s.wrappers = nil
+	return wrappers
+}
+
+// wrapNext returns next wrapped in the wrappers added since the last call,
+// clearing them.
+func (s *callState) wrapNext(next func() (interface{}, error)) func() (interface{}, error) {
+	// Wrap in reverse so that the first wrapper added is the outermost
+	wrappers := s.takeWrappers()
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		wrap, inner := wrappers[i], next
+		next = func() (interface{}, error) {
+			return wrap(inner)
+		}
+	}
+	return next
+}
+
+// proxyState returns the state of the proxy that started the call, or nil if
+// the call was not made by a proxy or is nested in another call.
+func (s *callState) proxyState() *proxyState {
+	if s == nil {
+		return nil
+	}
+	return s.proxy
+}
+
+type contextProxyStateKey struct{}
+
+// proxyState holds the values that hooks pass to the proxy that made a call,
+// for it to act on when writing the response. It belongs to the outermost
+// call the proxy makes, and calls nested in that call's handler do not see it.
+type proxyState struct {
+	mu      sync.Mutex
+	claimed bool
+	cors    *CORSPolicy
+	body    *encodedBody
+}
+
+// withProxyState returns a context with a new proxy state, for a proxy to
+// pass to API.Call.
+func withProxyState(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextProxyStateKey{}, &proxyState{})
+}
+
+func contextProxyState(ctx context.Context) *proxyState {
+	if ctx == nil {
+		return nil
+	}
+	state, _ := ctx.Value(contextProxyStateKey{}).(*proxyState)
+	return state
+}
+
+// claim reports whether the proxy state was not yet claimed by a call, and
+// claims it.
+func (p *proxyState) claim() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.claimed {
+		return false
+	}
+	p.claimed = true
+	return true
+}
+
+func (p *proxyState) setCORSPolicy(policy *CORSPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cors = policy
+}
+
+func (p *proxyState) corsPolicy() *CORSPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cors
+}
+
+func (p *proxyState) setEncodedBody(body *encodedBody) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.body = body
+}
+
+func (p *proxyState) takeEncodedBody() *encodedBody {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	body := p.body
+	p.body = nil
+	return body
+}
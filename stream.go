@@ -0,0 +1,94 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+)
+
+// NewStreamingJSONHook returns a post-request hook that streams the slice or
+// channel output of a successful call as newline-delimited JSON, with the
+// Content-Type application/x-ndjson, instead of marshalling it as one array.
+// Each element is written on its own line and flushed, so that clients can
+// process large lists as they arrive. Channels are read until they are closed,
+// or until the call's context is done, so handlers can send results as they
+// are produced from another goroutine, and must close the channel when done.
+// HTTPProxy cancels the context when the client goes away, after which the
+// channel is no longer read, so a goroutine sending on it must also stop when
+// the context passed to the handler is done.
+//
+// Streaming only works with HTTPProxy. Other outputs, and calls through
+// LambdaProxy or API.Call used directly, are left unchanged, so slices are sent
+// as JSON arrays there. Errors encoding an element after the response has
+// started cannot be reported to the client, and are logged instead. Add the
+// hook to an endpoint with After, before any other post-request hooks, so that
+// it runs last.
+func NewStreamingJSONHook() PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		w := ContextHTTPResponseWriter(input.Ctx)
+		if err != nil || w == nil {
+			return out, err
+		}
+		items := reflect.ValueOf(out)
+		switch {
+		case items.Kind() == reflect.Slice && items.Type().Elem().Kind() != reflect.Uint8:
+		case items.Kind() == reflect.Chan && items.Type().ChanDir()&reflect.RecvDir != 0:
+		default:
+			return out, err
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.WriteHeader(http.StatusOK)
+		if err := writeNDJSON(input.Ctx, w, items); err != nil {
+			contextLogger(input.Ctx).Printf("Error streaming response to %s %s: %v\n", input.Method, input.Path, err)
+		}
+		return responseWritten{}, nil
+	}
+}
+
+// writeNDJSON writes each element of a slice or channel to w as a line of
+// JSON, flushing after each one. It stops reading a channel when it is closed
+// or ctx is done.
+func writeNDJSON(ctx context.Context, w http.ResponseWriter, items reflect.Value) error {
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	write := func(item reflect.Value) error {
+		if err := encoder.Encode(item.Interface()); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	if items.Kind() == reflect.Slice {
+		for i := 0; i < items.Len(); i++ {
+			if err := write(items.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if items.IsNil() {
+		return nil
+	}
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: items},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+	for {
+		chosen, item, ok := reflect.Select(cases)
+		if chosen == 1 {
+			return ctx.Err()
+		}
+		if !ok {
+			return nil
+		}
+		if err := write(item); err != nil {
+			return err
+		}
+	}
+}
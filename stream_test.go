@@ -0,0 +1,91 @@
+package dispatch
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestStreamingJSONHook(t *testing.T) {
+	type item struct {
+		ID int `json:"id"`
+	}
+	api := API{}
+	api.AddEndpoint("GET/slice", func() []item { return []item{{1}, {2}} }, After(NewStreamingJSONHook()))
+	api.AddEndpoint("GET/chan", func() <-chan item {
+		items := make(chan item)
+		go func() {
+			defer close(items)
+			for i := 1; i <= 3; i++ {
+				items <- item{i}
+			}
+		}()
+		return items
+	}, After(NewStreamingJSONHook()))
+	api.AddEndpoint("GET/single", func() item { return item{1} }, After(NewStreamingJSONHook()))
+
+	for path, expected := range map[string]string{
+		"/slice":  "{\"id\":1}\n{\"id\":2}\n",
+		"/chan":   "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n",
+		"/single": `{"id":1}`,
+	} {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Body.String() != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, rec.Body)
+		}
+		if contentType := rec.Header().Get("Content-Type"); (path != "/single") != (contentType == "application/x-ndjson") {
+			t.Errorf("%s: unexpected Content-Type %q", path, contentType)
+		}
+	}
+
+	// Slices are sent as arrays outside of HTTPProxy
+	resp, err := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/slice"})
+	if err != nil || resp.Body != `[{"id":1},{"id":2}]` {
+		t.Errorf("Unexpected Lambda response %v, %v", resp, err)
+	}
+}
+
+func TestStreamingJSONHookCanceled(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/forever", func() chan int { return make(chan int) }, After(NewStreamingJSONHook()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/forever", nil).WithContext(ctx))
+	if rec.Body.Len() != 0 {
+		t.Errorf("Unexpected body %q", rec.Body)
+	}
+}
+
+func TestStreamingJSONHookHandlerCanceled(t *testing.T) {
+	stopped := make(chan struct{})
+	api := API{}
+	api.AddEndpoint("GET/forever", func(ctx context.Context) chan int {
+		items := make(chan int)
+		go func() {
+			defer close(stopped)
+			for i := 0; ; i++ {
+				select {
+				case items <- i:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return items
+	}, After(NewStreamingJSONHook()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	api.HTTPProxy(httptest.NewRecorder(), httptest.NewRequest("GET", "/forever", nil).WithContext(ctx))
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected the handler's context to be done when the client goes away")
+	}
+}
@@ -0,0 +1,42 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// A Tenant is a customer of a multi-tenant API, with its configuration.
+type Tenant struct {
+	ID     string
+	Config map[string]interface{}
+}
+
+// A TenantStore looks up tenants by ID. Lookup should return an error wrapping
+// ErrNotFound if there is no tenant with the ID.
+type TenantStore interface {
+	Lookup(ctx context.Context, tenantID string) (Tenant, error)
+}
+
+// NewTenantHook returns a middleware hook that resolves the tenant named by the
+// tenantHeader request header, such as X-Tenant-ID, and stores it in the
+// context, where handlers can read it with ContextTenant. Requests without the
+// header fail with status 400, and requests for an unknown tenant fail with
+// status 404. Other errors from the store are returned as-is.
+func NewTenantHook(tenantHeader string, store TenantStore) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		tenantID := input.Headers.Get(tenantHeader)
+		if tenantID == "" {
+			return nil, NewAPIError(http.StatusBadRequest, "missing required header: "+tenantHeader)
+		}
+		tenant, err := store.Lookup(input.Ctx, tenantID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewAPIError(http.StatusNotFound, "tenant not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		input.Ctx = SetContextTenant(input.Ctx, tenant)
+		return input, nil
+	}
+}
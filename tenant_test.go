@@ -0,0 +1,43 @@
+package dispatch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testTenantStore is a TenantStore backed by a map.
+type testTenantStore map[string]Tenant
+
+func (s testTenantStore) Lookup(ctx context.Context, tenantID string) (Tenant, error) {
+	tenant, ok := s[tenantID]
+	if !ok {
+		return Tenant{}, ErrNotFound
+	}
+	return tenant, nil
+}
+
+func TestTenantHook(t *testing.T) {
+	store := testTenantStore{"acme": {ID: "acme", Config: map[string]interface{}{"plan": "pro"}}}
+	handler := func(ctx context.Context) interface{} {
+		return ContextTenant(ctx).Config["plan"]
+	}
+	api := API{}
+	api.AddEndpoint("GET/plan", handler, NewTenantHook("X-Tenant-ID", store))
+
+	for tenantID, expected := range map[string]int{"acme": http.StatusOK, "other": http.StatusNotFound, "": http.StatusBadRequest} {
+		req := httptest.NewRequest("GET", "/plan", nil)
+		if tenantID != "" {
+			req.Header.Set("X-Tenant-ID", tenantID)
+		}
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, req)
+		if rec.Code != expected {
+			t.Errorf("Tenant %q: expected %d, got %d %s", tenantID, expected, rec.Code, rec.Body)
+		}
+		if tenantID == "acme" && rec.Body.String() != `"pro"` {
+			t.Errorf("Unexpected body %s", rec.Body)
+		}
+	}
+}
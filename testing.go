@@ -0,0 +1,48 @@
+package dispatch
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// A CallRecorder records the calls made to an API after API.WithRecorder.
+type CallRecorder struct {
+	mu    sync.Mutex
+	Calls []RecordedCall
+}
+
+// A RecordedCall is a single call made through API.Call and its result.
+type RecordedCall struct {
+	Method string
+	Path   string
+	Input  json.RawMessage
+	Output interface{}
+	Err    error
+}
+
+func (r *CallRecorder) record(call RecordedCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Calls = append(r.Calls, call)
+}
+
+// WithRecorder records every call made to the API from now on, including calls
+// from its proxies, in the returned CallRecorder, so tests can check the
+// results of calls without running an HTTP server. It adds a hook to the start
+// of api.GlobalHooks, so each call is recorded with the method, path, and
+// input it was made with, and the output and error it returned to the caller.
+// WithRecorder must not be called while the API is serving requests.
+func (api *API) WithRecorder() *CallRecorder {
+	recorder := &CallRecorder{}
+	hook := func(input *EndpointInput) (*EndpointInput, error) {
+		call := RecordedCall{Method: input.Method, Path: input.Path, Input: input.Input}
+		wrapCall(input.Ctx, func(next func() (interface{}, error)) (interface{}, error) {
+			call.Output, call.Err = next()
+			recorder.record(call)
+			return call.Output, call.Err
+		})
+		return input, nil
+	}
+	api.GlobalHooks = append([]MiddlewareHook{hook}, api.GlobalHooks...)
+	return recorder
+}
@@ -0,0 +1,49 @@
+package dispatch
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithRecorder(t *testing.T) {
+	api := &API{}
+	api.AddEndpoint("POST/double", WrapHandler(testTypedHandler))
+	api.AddEndpoint("GET/apiErrorTest", testAPIErrors)
+
+	api.Call(context.Background(), "GET", "/apiErrorTest", nil)
+	recorder := api.WithRecorder()
+	api.Call(context.Background(), "POST", "/double", []byte(`{"Var2": 3}`))
+	api.Call(context.Background(), "GET", "/apiErrorTest", nil)
+
+	if len(recorder.Calls) != 2 {
+		t.Fatalf("Expected 2 recorded calls, got %d", len(recorder.Calls))
+	}
+	if call := recorder.Calls[0]; call.Method != "POST" || call.Path != "/double" || string(call.Input) != `{"Var2": 3}` || call.Output.(int) != 6 || call.Err != nil {
+		t.Errorf("Unexpected call %+v", call)
+	}
+	if call := recorder.Calls[1]; call.Err == nil || call.Err.Error() != "I'm a teapot" {
+		t.Errorf("Unexpected call %+v", call)
+	}
+
+	// Endpoints added after WithRecorder are recorded, including calls through
+	// the proxies.
+	api.AddEndpoint("GET/late", func() string { return "late" })
+	w := httptest.NewRecorder()
+	api.HTTPProxy(w, httptest.NewRequest("GET", "/late", nil))
+	if w.Code != 200 || w.Body.String() != `"late"` {
+		t.Errorf("Unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if len(recorder.Calls) != 3 || recorder.Calls[2].Path != "/late" {
+		t.Errorf("Expected the proxied call to be recorded, got %+v", recorder.Calls)
+	}
+
+	// Errors from global hooks after the recorder are recorded too.
+	api.GlobalHooks = append(api.GlobalHooks, func(*EndpointInput) (*EndpointInput, error) {
+		return nil, ErrBadRequest
+	})
+	api.Call(context.Background(), "GET", "/late", nil)
+	if len(recorder.Calls) != 4 || recorder.Calls[3].Err != ErrBadRequest {
+		t.Errorf("Expected the failed call to be recorded, got %+v", recorder.Calls)
+	}
+}
@@ -0,0 +1,762 @@
+package dispatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/nyaruka/phonenumbers"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NewRequestTransformHook returns a middleware hook that replaces the request
+// body with the result of transform before it reaches the handler, such as to
+// rename fields for backwards compatibility or to fill in defaults. If
+// transform returns an error, the call fails with status 400 and the error's
+// message.
+func NewRequestTransformHook(transform func(json.RawMessage) (json.RawMessage, error)) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		body, err := transform(input.Input)
+		if err != nil {
+			return nil, NewAPIError(http.StatusBadRequest, err.Error())
+		}
+		input.Input = body
+		return input, nil
+	}
+}
+
+// NewResponseTransformHook returns a post-request hook that replaces the output
+// of a successful call with the result of transform, before it is marshalled
+// to JSON. If the output is a *Response, transform is applied to its body.
+// Upstream *http.Response outputs are left unchanged. If transform returns an
+// error, the call fails with it, which the proxies report with status 500
+// unless it is an *APIError. Add the hook to an endpoint with After.
+func NewResponseTransformHook(transform func(interface{}) (interface{}, error)) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return out, err
+		}
+		return transformOutput(out, transform)
+	}
+}
+
+// transformOutput applies transform to the data in a call's output: the body
+// of a *Response, or the output itself. Outputs that are not marshalled to
+// JSON, such as an upstream *http.Response, are returned unchanged.
+func transformOutput(out interface{}, transform func(interface{}) (interface{}, error)) (interface{}, error) {
+	switch resp := out.(type) {
+	case *http.Response, responseWritten:
+		return out, nil
+	case *Response:
+		if resp == nil {
+			return out, nil
+		}
+		body, err := transform(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		transformed := *resp
+		transformed.Body = body
+		return &transformed, nil
+	}
+	return transform(out)
+}
+
+// NewFieldFilterHook returns a post-request hook that limits the top-level JSON
+// fields in responses to those allowed for the type of client making the
+// request, named in the X-Client-Type request header. For example, mobile
+// clients can be sent a smaller response than web clients. If the output is an
+// array, the fields of each object in it are filtered. Requests from client
+// types not in allowedFields get the full response. Add the hook to an
+// endpoint with After.
+func NewFieldFilterHook(allowedFields map[string][]string) PostRequestHook {
+	allowed := make(map[string]map[string]bool, len(allowedFields))
+	for clientType, fields := range allowedFields {
+		allowed[clientType] = make(map[string]bool, len(fields))
+		for _, field := range fields {
+			allowed[clientType][field] = true
+		}
+	}
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		fields, ok := allowed[input.Headers.Get("X-Client-Type")]
+		if err != nil || !ok {
+			return out, err
+		}
+		return transformOutput(out, func(data interface{}) (interface{}, error) {
+			value, err := toJSONValue(data)
+			if err != nil {
+				return nil, err
+			}
+			if items, ok := value.([]interface{}); ok {
+				for i, item := range items {
+					items[i] = filterFields(item, fields)
+				}
+				return items, nil
+			}
+			return filterFields(value, fields), nil
+		})
+	}
+}
+
+// filterFields removes the fields of a JSON object that are not allowed. Other
+// values are returned unchanged.
+func filterFields(value interface{}, allowed map[string]bool) interface{} {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	for key := range obj {
+		if !allowed[key] {
+			delete(obj, key)
+		}
+	}
+	return obj
+}
+
+// toJSONValue converts a value to its generic JSON representation, with maps
+// for objects and slices for arrays, by marshalling and unmarshalling it.
+// Numbers are kept as json.Number to preserve their precision.
+func toJSONValue(data interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var value interface{}
+	err = decoder.Decode(&value)
+	return value, err
+}
+
+// NewCamelCaseHook returns a post-request hook that converts the object keys in
+// responses to camelCase, at any depth, so that Go structs can be returned
+// without JSON tags. Both snake_case keys, such as user_id, and Go field names,
+// such as UserID, are converted, to userId and userID respectively. The output
+// is marshalled to JSON by the hook and returned as a json.RawMessage. Add the
+// hook to an endpoint with After.
+func NewCamelCaseHook() PostRequestHook {
+	return keyCaseHook(camelCase)
+}
+
+// keyCaseHook returns a post-request hook that renames the object keys in
+// responses with rename.
+func keyCaseHook(rename func(string) string) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return out, err
+		}
+		return transformOutput(out, func(data interface{}) (interface{}, error) {
+			if data == nil {
+				return nil, nil
+			}
+			value, err := toJSONValue(data)
+			if err != nil {
+				return nil, err
+			}
+			encoded, err := json.Marshal(renameKeys(value, rename))
+			return json.RawMessage(encoded), err
+		})
+	}
+}
+
+// renameKeys renames the keys of the objects in a JSON value, at any depth.
+func renameKeys(value interface{}, rename func(string) string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			renamed[rename(key)] = renameKeys(field, rename)
+		}
+		return renamed
+	case []interface{}:
+		for i, item := range v {
+			v[i] = renameKeys(item, rename)
+		}
+		return v
+	}
+	return value
+}
+
+// camelCase converts a snake_case or PascalCase name to camelCase. A leading
+// initialism is lowercased as a whole, so that HTTPServer becomes httpServer.
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	var b strings.Builder
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		runes := []rune(part)
+		if b.Len() == 0 {
+			// Lowercase the leading run of capitals, except for one that
+			// starts the next word
+			for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+				if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+					break
+				}
+				runes[i] = unicode.ToLower(runes[i])
+			}
+		} else {
+			runes[0] = unicode.ToUpper(runes[0])
+		}
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
+
+// NewEnvelopeHook returns a post-request hook that wraps the output of each
+// successful call that has one in the envelope returned by wrapper, such as
+// {"data": ..., "meta": ...}. The meta map holds the request ID, as requestId,
+// if there is one, the page and pageSize set by NewPaginationHook, and the API
+// version from ContextAPIVersion, as apiVersion. Add the hook to an endpoint
+// with After, before NewVersionHeaderHook so that it runs after it and sees the
+// version.
+func NewEnvelopeHook(wrapper func(data interface{}, meta map[string]interface{}) interface{}) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil || out == nil {
+			return out, err
+		}
+		return transformOutput(out, func(data interface{}) (interface{}, error) {
+			if data == nil {
+				return nil, nil
+			}
+			return wrapper(data, envelopeMeta(input.Ctx)), nil
+		})
+	}
+}
+
+// envelopeMeta returns the metadata for NewEnvelopeHook from ctx.
+func envelopeMeta(ctx context.Context) map[string]interface{} {
+	meta := make(map[string]interface{})
+	if id := ContextRequestID(ctx); id != "" {
+		meta["requestId"] = id
+	}
+	if pagination := ContextPagination(ctx); pagination.Page > 0 {
+		meta["page"] = pagination.Page
+		meta["pageSize"] = pagination.PageSize
+	}
+	if version := ContextAPIVersion(ctx); version != "" {
+		meta["apiVersion"] = version
+	}
+	return meta
+}
+
+// NewHATEOASHook returns a post-request hook that adds links to related
+// resources to each successful response that is a JSON object, in a _links
+// field mapping each relation returned by linker to its URL. Other responses,
+// and responses for which linker returns no links, are left unchanged. Add the
+// hook to an endpoint with After.
+func NewHATEOASHook(linker func(context.Context, interface{}) map[string]string) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return out, err
+		}
+		return transformOutput(out, func(data interface{}) (interface{}, error) {
+			links := linker(input.Ctx, data)
+			if len(links) == 0 {
+				return data, nil
+			}
+			value, err := toJSONValue(data)
+			if err != nil {
+				return nil, err
+			}
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return data, nil
+			}
+			obj["_links"] = links
+			return obj, nil
+		})
+	}
+}
+
+// NewSnakeCaseHook returns a post-request hook that converts the object keys in
+// responses to snake_case, at any depth, as is conventional for Python and
+// Ruby APIs. Keys such as UserID and userId both become user_id. It is the
+// inverse of NewCamelCaseHook. The output is marshalled to JSON by the hook and
+// returned as a json.RawMessage. Add the hook to an endpoint with After.
+func NewSnakeCaseHook() PostRequestHook {
+	return keyCaseHook(snakeCase)
+}
+
+// snakeCase converts a camelCase or PascalCase name to snake_case. An
+// initialism is treated as one word, so that HTTPServer becomes http_server.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a new word after a lowercase letter or digit, or at the
+			// last capital of an initialism that is followed by a word
+			startsWord := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])))
+			if startsWord && runes[i-1] != '_' {
+				b.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// NewTrimHook returns a middleware hook that trims leading and trailing
+// whitespace from all of the strings in the request's JSON body, at any depth.
+// Object keys are not changed. Bodies that are empty or not valid JSON are
+// passed on unchanged, for the handler to reject.
+func NewTrimHook() MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		value, ok := decodeJSONBody(input.Input)
+		if !ok {
+			return input, nil
+		}
+		body, err := json.Marshal(trimStrings(value))
+		if err != nil {
+			return nil, err
+		}
+		input.Input = body
+		return input, nil
+	}
+}
+
+// decodeJSONBody decodes a request body, keeping numbers as json.Number so
+// that they are encoded again unchanged. It returns false if the body is empty
+// or not valid JSON.
+func decodeJSONBody(body []byte) (interface{}, bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, false
+	}
+	return value, true
+}
+
+// trimStrings trims whitespace from the strings in a JSON value, at any depth.
+func trimStrings(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]interface{}:
+		for key, field := range v {
+			v[key] = trimStrings(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = trimStrings(item)
+		}
+	}
+	return value
+}
+
+// NewLowercaseEmailHook returns a middleware hook that lowercases the values of
+// the given top-level fields of the request's JSON body, such as email, so that
+// addresses with different casing are stored the same way. Requests where one
+// of the fields is present but is not a string or null fail with status 400.
+// Bodies that are empty or not JSON objects are passed on unchanged.
+func NewLowercaseEmailHook(fields ...string) MiddlewareHook {
+	return normalizeFieldsHook(fields, func(field string, value interface{}) (interface{}, error) {
+		s, ok := value.(string)
+		if !ok {
+			return nil, NewAPIError(http.StatusBadRequest, field+" must be a string")
+		}
+		return strings.ToLower(s), nil
+	})
+}
+
+// normalizeFieldsHook returns a middleware hook that replaces the values of the
+// given top-level fields of the request's JSON body with those returned by
+// normalize, which fails the request if it returns an error. Fields that are
+// missing or null are not changed, and bodies that are empty or not JSON
+// objects are passed on unchanged.
+func normalizeFieldsHook(fields []string, normalize func(field string, value interface{}) (interface{}, error)) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		value, _ := decodeJSONBody(input.Input)
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return input, nil
+		}
+		for _, field := range fields {
+			if object[field] == nil {
+				continue
+			}
+			normalized, err := normalize(field, object[field])
+			if err != nil {
+				return nil, err
+			}
+			object[field] = normalized
+		}
+		body, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		input.Input = body
+		return input, nil
+	}
+}
+
+// NewQueryToInputHook returns a middleware hook that copies query parameters
+// into the request's JSON body, for clients that send data in the query string
+// instead of the body. mapping maps the names of query parameters to the names
+// of the body fields to set, and only the first value of each parameter is
+// used. Values are set as JSON strings, so numeric fields of the handler's
+// input need the ",string" JSON tag option. Fields already in the body take
+// precedence. Requests with a body that is not a JSON object fail with status
+// 400.
+func NewQueryToInputHook(mapping map[string]string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		fields := make(map[string]interface{})
+		for param, field := range mapping {
+			if values, ok := input.QueryParams[param]; ok && len(values) > 0 {
+				fields[field] = values[0]
+			}
+		}
+		if err := mergeIntoBody(input, fields, false); err != nil {
+			return nil, err
+		}
+		return input, nil
+	}
+}
+
+// mergeIntoBody sets fields in the request's JSON body, which is treated as an
+// empty object if it is empty or null. Fields already in the body are only
+// replaced if overwrite is true. The body is not changed if there are no
+// fields to set.
+func mergeIntoBody(input *EndpointInput, fields map[string]interface{}, overwrite bool) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	object := make(map[string]json.RawMessage)
+	if body := bytes.TrimSpace(input.Input); len(body) > 0 && !bytes.Equal(body, []byte("null")) {
+		if err := json.Unmarshal(body, &object); err != nil || object == nil {
+			return NewAPIError(http.StatusBadRequest, "request body must be a JSON object")
+		}
+	}
+	for name, value := range fields {
+		if _, ok := object[name]; ok && !overwrite {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		object[name] = encoded
+	}
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	input.Input = body
+	return nil
+}
+
+// NewPathVarToInputHook returns a middleware hook that copies path variables
+// into the request's JSON body, so that a handler's struct input can hold both
+// the body and the path variables. mapping maps the names of path variables to
+// the names of the body fields to set. Values are set as JSON strings, as
+// NewQueryToInputHook does. Path variables replace fields of the same name in
+// the body, so that clients cannot change the resource a request applies to.
+// Requests with a body that is not a JSON object fail with status 400.
+func NewPathVarToInputHook(mapping map[string]string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		pathVars := ContextPathVars(input.Ctx)
+		fields := make(map[string]interface{})
+		for name, field := range mapping {
+			if value, ok := pathVars[name]; ok {
+				fields[field] = value
+			}
+		}
+		if err := mergeIntoBody(input, fields, true); err != nil {
+			return nil, err
+		}
+		return input, nil
+	}
+}
+
+// NewDefaultValuesHook returns a middleware hook that sets the fields in
+// defaults in the request's JSON body, for those fields the client omitted.
+// Fields in the body, including those set to null, are not replaced. Requests
+// with a body that is not a JSON object fail with status 400.
+func NewDefaultValuesHook(defaults map[string]interface{}) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if err := mergeIntoBody(input, defaults, false); err != nil {
+			return nil, err
+		}
+		return input, nil
+	}
+}
+
+// NewBase64DecodeHook returns a middleware hook that decodes the base64 values
+// of the given top-level fields of the request's JSON body, replacing each with
+// a JSON array of the decoded bytes, which the handler can unmarshal into a
+// []byte field. Standard and URL-safe encodings are accepted, with or without
+// padding. Requests where one of the fields is not a string or null, or is not
+// valid base64, fail with status 400. Bodies that are empty or not JSON objects
+// are passed on unchanged.
+func NewBase64DecodeHook(fields ...string) MiddlewareHook {
+	return normalizeFieldsHook(fields, func(field string, value interface{}) (interface{}, error) {
+		s, ok := value.(string)
+		if !ok {
+			return nil, NewAPIError(http.StatusBadRequest, field+" must be a base64 string")
+		}
+		data, ok := decodeBase64(s)
+		if !ok {
+			return nil, NewAPIError(http.StatusBadRequest, field+" is not valid base64")
+		}
+		byteValues := make([]int, len(data))
+		for i, b := range data {
+			byteValues[i] = int(b)
+		}
+		return byteValues, nil
+	})
+}
+
+// decodeBase64 decodes s with whichever of the standard and URL-safe base64
+// encodings, padded or not, it is valid for.
+func decodeBase64(s string) ([]byte, bool) {
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := encoding.DecodeString(s); err == nil {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// timestampFormats are the layouts NewTimestampNormalizeHook accepts, in the
+// order they are tried. Layouts without a time zone are read as UTC.
+var timestampFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// NewTimestampNormalizeHook returns a middleware hook that rewrites the values
+// of the given top-level fields of the request's JSON body as RFC 3339 strings
+// in UTC, so that handlers can unmarshal them into time.Time fields. Values may
+// be Unix times in seconds or milliseconds, as numbers or strings, or strings
+// in ISO 8601 and other common formats, with or without a time zone. Requests
+// where one of the fields cannot be parsed as a timestamp fail with status 400.
+// Fields that are missing or null, and bodies that are empty or not JSON
+// objects, are not changed.
+func NewTimestampNormalizeHook(fields ...string) MiddlewareHook {
+	return normalizeFieldsHook(fields, func(field string, value interface{}) (interface{}, error) {
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = strings.TrimSpace(v)
+		case json.Number:
+			s = v.String()
+		}
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return nil, NewAPIError(http.StatusBadRequest, "invalid timestamp: "+field)
+		}
+		return t.UTC().Format(time.RFC3339Nano), nil
+	})
+}
+
+// parseTimestamp parses a Unix time or a timestamp in one of timestampFormats.
+// Unix times of 1e11 or more, which would be thousands of years from now in
+// seconds, are read as milliseconds.
+func parseTimestamp(s string) (time.Time, bool) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n >= 1e11 || n <= -1e11 {
+			return time.UnixMilli(n), true
+		}
+		return time.Unix(n, 0), true
+	}
+	for _, layout := range timestampFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// NewUUIDNormalizeHook returns a middleware hook that rewrites the UUID values
+// of the given top-level fields of the request's JSON body in their canonical
+// form, lowercase and with hyphens. UUIDs may be in either case, with or
+// without hyphens, braces, or a urn:uuid: prefix. Requests where one of the
+// fields is not a valid UUID fail with status 400. Fields that are missing or
+// null, and bodies that are empty or not JSON objects, are not changed.
+func NewUUIDNormalizeHook(fields ...string) MiddlewareHook {
+	return normalizeFieldsHook(fields, func(field string, value interface{}) (interface{}, error) {
+		s, _ := value.(string)
+		id, err := uuid.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, NewAPIError(http.StatusBadRequest, "invalid UUID: "+field)
+		}
+		return id.String(), nil
+	})
+}
+
+// NewPhoneNormalizeHook returns a middleware hook that rewrites the phone
+// numbers in the given top-level fields of the request's JSON body in E.164
+// format, such as +16502530000. Numbers without a country calling code are
+// read as numbers in the region with the ISO 3166-1 code countryCode, such as
+// US. Requests where one of the fields is not a valid phone number fail with
+// status 400. Fields that are missing or null, and bodies that are empty or not
+// JSON objects, are not changed.
+func NewPhoneNormalizeHook(countryCode string, fields ...string) MiddlewareHook {
+	return normalizeFieldsHook(fields, func(field string, value interface{}) (interface{}, error) {
+		s, _ := value.(string)
+		number, err := phonenumbers.Parse(s, strings.ToUpper(countryCode))
+		if err != nil || !phonenumbers.IsValidNumber(number) {
+			return nil, NewAPIError(http.StatusBadRequest, "invalid phone number: "+field)
+		}
+		return phonenumbers.Format(number, phonenumbers.E164), nil
+	})
+}
+
+// NewSlugifyHook returns a middleware hook that adds URL slugs generated from
+// the values of top-level fields of the request's JSON body. Each of fields is
+// either the name of a field, whose slug is set in the slug field, or a
+// source:target pair naming both fields. Slugs are lowercase, with accents
+// removed and runs of characters other than letters and digits replaced by
+// single hyphens, so that Crème Brûlée becomes creme-brulee. Letters in other
+// scripts are kept. Slugs the client sent are not replaced. Requests where a
+// source field is not a string fail with status 400. Fields that are missing or
+// null, and bodies that are empty or not JSON objects, are not changed.
+func NewSlugifyHook(fields ...string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		value, _ := decodeJSONBody(input.Input)
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return input, nil
+		}
+		for _, field := range fields {
+			source, target, ok := strings.Cut(field, ":")
+			if !ok {
+				target = "slug"
+			}
+			if object[source] == nil || object[target] != nil {
+				continue
+			}
+			s, ok := object[source].(string)
+			if !ok {
+				return nil, NewAPIError(http.StatusBadRequest, source+" must be a string")
+			}
+			object[target] = slugify(s)
+		}
+		body, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		input.Input = body
+		return input, nil
+	}
+}
+
+// slugify converts s to a slug for NewSlugifyHook. Accents are only removed
+// from Latin letters, since marks such as the Japanese dakuten change the
+// letter they are on.
+func slugify(s string) string {
+	var slug strings.Builder
+	hyphen := false
+	writeRune := func(r rune) {
+		if hyphen && slug.Len() > 0 {
+			slug.WriteByte('-')
+		}
+		hyphen = false
+		slug.WriteRune(unicode.ToLower(r))
+	}
+	for _, r := range norm.NFC.String(s) {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			for _, decomposed := range norm.NFKD.String(string(r)) {
+				if !unicode.Is(unicode.Mn, decomposed) {
+					writeRune(decomposed)
+				}
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			writeRune(r)
+		default:
+			hyphen = true
+		}
+	}
+	return slug.String()
+}
+
+// NewSecretMaskHook returns a post-request hook that replaces the values of the
+// response fields at fieldPaths with "[MASKED]", so that secrets such as API
+// keys are not exposed if a handler returns them by mistake. Paths name fields
+// in nested objects with dots, such as user.apiKey. Arrays along a path, and an
+// array output, have the path applied to each of their elements. Fields that
+// are missing are not added. Add the hook to an endpoint with After.
+func NewSecretMaskHook(fieldPaths []string) PostRequestHook {
+	paths := make([][]string, len(fieldPaths))
+	for i, path := range fieldPaths {
+		paths[i] = strings.Split(path, ".")
+	}
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return out, err
+		}
+		return transformOutput(out, func(data interface{}) (interface{}, error) {
+			value, err := toJSONValue(data)
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				maskField(value, path)
+			}
+			return value, nil
+		})
+	}
+}
+
+// maskField masks the field at path in a JSON value, for NewSecretMaskHook.
+func maskField(value interface{}, path []string) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			maskField(item, path)
+		}
+	case map[string]interface{}:
+		field, ok := v[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			v[path[0]] = "[MASKED]"
+			return
+		}
+		maskField(field, path[1:])
+	}
+}
+
+// NewConditionalResponseHook returns a post-request hook that replaces the
+// output of a successful call with the result of transform, only if pred
+// returns true for it. For example, pred can check the JWT claims in ctx so
+// that admin-only fields are only added for admins. As with
+// NewResponseTransformHook, pred and transform get the body of a *Response
+// output, and upstream *http.Response outputs are left unchanged. Add the hook
+// to an endpoint with After.
+func NewConditionalResponseHook(pred func(ctx context.Context, out interface{}) bool, transform func(interface{}) interface{}) PostRequestHook {
+	return func(input *EndpointInput, out interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return out, err
+		}
+		return transformOutput(out, func(data interface{}) (interface{}, error) {
+			if !pred(input.Ctx, data) {
+				return data, nil
+			}
+			return transform(data), nil
+		})
+	}
+}
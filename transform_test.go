@@ -0,0 +1,491 @@
+package dispatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestTransformHook(t *testing.T) {
+	rename := func(body json.RawMessage) (json.RawMessage, error) {
+		if !json.Valid(body) {
+			return nil, errors.New("invalid body")
+		}
+		return bytes.Replace(body, []byte(`"old"`), []byte(`"foo"`), 1), nil
+	}
+	api := API{}
+	api.AddEndpoint("POST/items", func(in testInputType) string { return in.Var1 }, NewRequestTransformHook(rename))
+
+	if out, err := api.Call(context.Background(), "POST", "/items", []byte(`{"old":"x"}`)); out != "x" || err != nil {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+	_, err := api.Call(context.Background(), "POST", "/items", []byte(`{`))
+	if ErrorStatusCode(err) != http.StatusBadRequest || err.Error() != "invalid body" {
+		t.Errorf("Expected 400 with transform error, got %v", err)
+	}
+}
+
+func TestResponseTransformHook(t *testing.T) {
+	addMeta := func(out interface{}) (interface{}, error) {
+		data, ok := out.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("unexpected output")
+		}
+		data["version"] = 2
+		return data, nil
+	}
+	hook := After(NewResponseTransformHook(addMeta))
+	api := API{}
+	api.AddEndpoint("GET/map", func() map[string]interface{} { return map[string]interface{}{"id": 1} }, hook)
+	api.AddEndpoint("GET/response", func() *Response {
+		return &Response{StatusCode: 201, Body: map[string]interface{}{"id": 1}}
+	}, hook)
+	api.AddEndpoint("GET/string", func() string { return "x" }, hook)
+
+	out, err := api.Call(context.Background(), "GET", "/map", nil)
+	if data, _ := out.(map[string]interface{}); err != nil || data["version"] != 2 {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+	out, err = api.Call(context.Background(), "GET", "/response", nil)
+	if resp, _ := out.(*Response); err != nil || resp.StatusCode != 201 || resp.Body.(map[string]interface{})["version"] != 2 {
+		t.Errorf("Unexpected result %v, %v", out, err)
+	}
+	if _, err := api.Call(context.Background(), "GET", "/string", nil); ErrorStatusCode(err) != http.StatusInternalServerError {
+		t.Errorf("Expected transform error, got %v", err)
+	}
+}
+
+func TestFieldFilterHook(t *testing.T) {
+	type user struct {
+		ID      int    `json:"id"`
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	}
+	hook := After(NewFieldFilterHook(map[string][]string{"mobile": {"id", "name"}}))
+	api := API{}
+	api.AddEndpoint("GET/user", func() user { return user{1, "alice", "1 Main St"} }, hook)
+	api.AddEndpoint("GET/users", func() []user { return []user{{1, "alice", "1 Main St"}} }, hook)
+
+	request := func(path, clientType string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Client-Type", clientType)
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, req)
+		return rec.Body.String()
+	}
+	if body := request("/user", "mobile"); body != `{"id":1,"name":"alice"}` {
+		t.Errorf("Unexpected mobile body %s", body)
+	}
+	if body := request("/users", "mobile"); body != `[{"id":1,"name":"alice"}]` {
+		t.Errorf("Unexpected mobile body %s", body)
+	}
+	if body := request("/user", "web"); body != `{"id":1,"name":"alice","address":"1 Main St"}` {
+		t.Errorf("Unexpected web body %s", body)
+	}
+}
+
+func TestCamelCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"user_id":    "userId",
+		"UserID":     "userID",
+		"HTTPServer": "httpServer",
+		"ID":         "id",
+		"name":       "name",
+		"_private":   "private",
+	} {
+		if got := camelCase(name); got != expected {
+			t.Errorf("camelCase(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestCamelCaseHook(t *testing.T) {
+	type address struct {
+		StreetName string
+	}
+	type user struct {
+		UserID  int
+		Address address
+		Tags    []map[string]string `json:"tag_list"`
+	}
+	api := API{}
+	handler := func() user { return user{7, address{"Main"}, []map[string]string{{"tag_name": "x"}}} }
+	api.AddEndpoint("GET/user", handler, After(NewCamelCaseHook()))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/user", nil))
+	expected := `{"address":{"streetName":"Main"},"tagList":[{"tagName":"x"}],"userID":7}`
+	if body := rec.Body.String(); body != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+}
+
+func TestEnvelopeHook(t *testing.T) {
+	wrapper := func(data interface{}, meta map[string]interface{}) interface{} {
+		return map[string]interface{}{"data": data, "meta": meta}
+	}
+	api := API{}
+	api.AddEndpoint("GET/item", func() string { return "x" }, After(NewEnvelopeHook(wrapper)))
+	api.AddEndpoint("GET/empty", func() {}, After(NewEnvelopeHook(wrapper)))
+
+	req := httptest.NewRequest("GET", "/item", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if body := rec.Body.String(); body != `{"data":"x","meta":{"requestId":"req-1"}}` {
+		t.Errorf("Unexpected body %s", body)
+	}
+
+	if out, err := api.Call(context.Background(), "GET", "/empty", nil); out != nil || err != nil {
+		t.Errorf("Expected empty output to be left alone, got %v, %v", out, err)
+	}
+
+	api.AddEndpoint("GET/versioned", func() string { return "x" }, After(NewEnvelopeHook(wrapper)), After(NewVersionHeaderHook("v2")))
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/versioned", nil))
+	if body := rec.Body.String(); body != `{"data":"x","meta":{"apiVersion":"v2"}}` {
+		t.Errorf("Unexpected body %s", body)
+	}
+}
+
+func TestHATEOASHook(t *testing.T) {
+	type order struct {
+		ID int `json:"id"`
+	}
+	linker := func(ctx context.Context, data interface{}) map[string]string {
+		o, ok := data.(order)
+		if !ok {
+			return nil
+		}
+		return map[string]string{"self": fmt.Sprintf("/orders/%d", o.ID)}
+	}
+	api := API{}
+	api.AddEndpoint("GET/order", func() order { return order{3} }, After(NewHATEOASHook(linker)))
+	api.AddEndpoint("GET/name", func() string { return "x" }, After(NewHATEOASHook(linker)))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/order", nil))
+	if body := rec.Body.String(); body != `{"_links":{"self":"/orders/3"},"id":3}` {
+		t.Errorf("Unexpected body %s", body)
+	}
+	if out, _ := api.Call(context.Background(), "GET", "/name", nil); out != "x" {
+		t.Errorf("Expected output without links to be unchanged, got %v", out)
+	}
+}
+
+func TestEnvelopeHookPagination(t *testing.T) {
+	wrapper := func(data interface{}, meta map[string]interface{}) interface{} {
+		return map[string]interface{}{"data": data, "meta": meta}
+	}
+	api := API{}
+	api.AddEndpoint("GET/items", func() []int { return []int{1} }, After(NewEnvelopeHook(wrapper)), NewPaginationHook(10, 50))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/items?page=2", nil))
+	if body := rec.Body.String(); body != `{"data":[1],"meta":{"page":2,"pageSize":10}}` {
+		t.Errorf("Unexpected body %s", body)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"UserID":     "user_id",
+		"userId":     "user_id",
+		"HTTPServer": "http_server",
+		"ID":         "id",
+		"Address2":   "address2",
+		"user_name":  "user_name",
+	} {
+		if got := snakeCase(name); got != expected {
+			t.Errorf("snakeCase(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestSnakeCaseHook(t *testing.T) {
+	type address struct {
+		StreetName string
+	}
+	type user struct {
+		UserID  int
+		Address address
+	}
+	api := API{}
+	api.AddEndpoint("GET/user", func() user { return user{7, address{"Main"}} }, After(NewSnakeCaseHook()))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/user", nil))
+	expected := `{"address":{"street_name":"Main"},"user_id":7}`
+	if body := rec.Body.String(); body != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+}
+
+func TestTrimHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/items", func(in json.RawMessage) json.RawMessage { return in }, NewTrimHook())
+
+	out, err := api.Call(context.Background(), "POST", "/items", []byte(`{" name ":"  a b ","tags":["\tx\n"],"n":1.50,"ok":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.(json.RawMessage)) != `{" name ":"a b","n":1.50,"ok":true,"tags":["x"]}` {
+		t.Errorf("Unexpected body %s", out)
+	}
+
+	// Invalid bodies are left for the handler
+	if _, err := api.Call(context.Background(), "POST", "/items", []byte(`{`)); err == nil {
+		t.Error("Expected invalid body to fail")
+	}
+}
+
+func TestLowercaseEmailHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/users", func(in json.RawMessage) json.RawMessage { return in }, NewLowercaseEmailHook("email", "backupEmail"))
+
+	out, err := api.Call(context.Background(), "POST", "/users", []byte(`{"email":"Jo@Example.COM","name":"Jo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.(json.RawMessage)) != `{"email":"jo@example.com","name":"Jo"}` {
+		t.Errorf("Unexpected body %s", out)
+	}
+
+	_, err = api.Call(context.Background(), "POST", "/users", []byte(`{"email":42}`))
+	if ErrorStatusCode(err) != http.StatusBadRequest || err.Error() != "email must be a string" {
+		t.Errorf("Expected 400 for non-string field, got %v", err)
+	}
+}
+
+func TestQueryToInputHook(t *testing.T) {
+	type searchInput struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit,string"`
+	}
+	api := API{}
+	api.AddEndpoint("POST/search", func(in searchInput) searchInput { return in }, NewQueryToInputHook(map[string]string{"q": "query", "limit": "limit"}))
+
+	request := func(target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("POST", target, strings.NewReader(body)))
+		return rec
+	}
+	if rec := request("/search?q=shoes&limit=5", ""); rec.Body.String() != `{"query":"shoes","limit":"5"}` {
+		t.Errorf("Unexpected response %d %s", rec.Code, rec.Body)
+	}
+	if rec := request("/search?q=shoes", `{"query":"boots"}`); rec.Body.String() != `{"query":"boots","limit":"0"}` {
+		t.Errorf("Expected body to take precedence, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := request("/search?q=shoes", `[]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for non-object body, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPathVarToInputHook(t *testing.T) {
+	type updateInput struct {
+		UserID string `json:"user_id"`
+		Name   string `json:"name"`
+	}
+	api := API{}
+	api.AddEndpoint("PUT/users/{userId}", func(ctx context.Context, in updateInput) updateInput { return in }, NewPathVarToInputHook(map[string]string{"userId": "user_id"}))
+
+	out, err := api.Call(context.Background(), "PUT", "/users/u1", []byte(`{"user_id":"u2","name":"Jo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in := out.(updateInput); in.UserID != "u1" || in.Name != "Jo" {
+		t.Errorf("Unexpected input %+v", in)
+	}
+}
+
+func TestDefaultValuesHook(t *testing.T) {
+	type listInput struct {
+		Page     int `json:"page"`
+		PageSize int `json:"page_size"`
+	}
+	api := API{}
+	api.AddEndpoint("POST/items/list", func(in listInput) listInput { return in }, NewDefaultValuesHook(map[string]interface{}{"page": 1, "page_size": 20}))
+
+	for body, expected := range map[string]listInput{
+		``:                {1, 20},
+		`{"page":3}`:      {3, 20},
+		`{"page_size":5}`: {1, 5},
+	} {
+		out, err := api.Call(context.Background(), "POST", "/items/list", []byte(body))
+		if err != nil || out != expected {
+			t.Errorf("Body %q: expected %+v, got %+v, %v", body, expected, out, err)
+		}
+	}
+}
+
+func TestBase64DecodeHook(t *testing.T) {
+	type uploadInput struct {
+		Data []byte `json:"data"`
+		Name string `json:"name"`
+	}
+	api := API{}
+	api.AddEndpoint("POST/uploads", func(in uploadInput) string { return string(in.Data) }, NewBase64DecodeHook("data"))
+
+	for _, encoded := range []string{"aGk/Pz4=", "aGk_Pz4"} {
+		out, err := api.Call(context.Background(), "POST", "/uploads", []byte(`{"data":"`+encoded+`","name":"f"}`))
+		if out != "hi??>" || err != nil {
+			t.Errorf("%s: unexpected result %v, %v", encoded, out, err)
+		}
+	}
+	for body, message := range map[string]string{
+		`{"data":"not base64!"}`: "data is not valid base64",
+		`{"data":[1,2]}`:         "data must be a base64 string",
+	} {
+		_, err := api.Call(context.Background(), "POST", "/uploads", []byte(body))
+		if ErrorStatusCode(err) != http.StatusBadRequest || err.Error() != message {
+			t.Errorf("Body %s: expected 400 %q, got %v", body, message, err)
+		}
+	}
+}
+
+func TestTimestampNormalizeHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/events", func(in json.RawMessage) json.RawMessage { return in }, NewTimestampNormalizeHook("at"))
+
+	for at, expected := range map[string]string{
+		`1700000000`:                  "2023-11-14T22:13:20Z",
+		`"1700000000123"`:             "2023-11-14T22:13:20.123Z",
+		`"2023-11-14T23:13:20+01:00"`: "2023-11-14T22:13:20Z",
+		`"2023-11-14T22:13:20"`:       "2023-11-14T22:13:20Z",
+		`"2023-11-14 22:13:20"`:       "2023-11-14T22:13:20Z",
+		`"2023-11-14"`:                "2023-11-14T00:00:00Z",
+	} {
+		out, err := api.Call(context.Background(), "POST", "/events", []byte(`{"at":`+at+`}`))
+		if err != nil || string(out.(json.RawMessage)) != `{"at":"`+expected+`"}` {
+			t.Errorf("%s: expected %s, got %s, %v", at, expected, out, err)
+		}
+	}
+	_, err := api.Call(context.Background(), "POST", "/events", []byte(`{"at":"yesterday"}`))
+	if ErrorStatusCode(err) != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid timestamp, got %v", err)
+	}
+}
+
+func TestUUIDNormalizeHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/things", func(in json.RawMessage) json.RawMessage { return in }, NewUUIDNormalizeHook("id", "parentId"))
+
+	out, err := api.Call(context.Background(), "POST", "/things", []byte(`{"id":"6BA7B8109DAD11D180B400C04FD430C8","parentId":"{6ba7b810-9dad-11d1-80b4-00c04fd430c8}"}`))
+	expected := `{"id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8","parentId":"6ba7b810-9dad-11d1-80b4-00c04fd430c8"}`
+	if err != nil || string(out.(json.RawMessage)) != expected {
+		t.Errorf("Unexpected result %s, %v", out, err)
+	}
+	_, err = api.Call(context.Background(), "POST", "/things", []byte(`{"id":"not-a-uuid"}`))
+	if ErrorStatusCode(err) != http.StatusBadRequest || err.Error() != "invalid UUID: id" {
+		t.Errorf("Expected 400 for invalid UUID, got %v", err)
+	}
+}
+
+func TestPhoneNormalizeHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/contacts", func(in json.RawMessage) json.RawMessage { return in }, NewPhoneNormalizeHook("US", "phone"))
+
+	for phone, expected := range map[string]string{
+		"(650) 253-0000":   "+16502530000",
+		"650.253.0000":     "+16502530000",
+		"+44 20 7031 3000": "+442070313000",
+	} {
+		out, err := api.Call(context.Background(), "POST", "/contacts", []byte(`{"phone":"`+phone+`"}`))
+		if err != nil || string(out.(json.RawMessage)) != `{"phone":"`+expected+`"}` {
+			t.Errorf("%s: expected %s, got %s, %v", phone, expected, out, err)
+		}
+	}
+	for _, body := range []string{`{"phone":"12"}`, `{"phone":"call me"}`, `{"phone":6502530000}`} {
+		_, err := api.Call(context.Background(), "POST", "/contacts", []byte(body))
+		if ErrorStatusCode(err) != http.StatusBadRequest || err.Error() != "invalid phone number: phone" {
+			t.Errorf("%s: expected 400, got %v", body, err)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	for s, expected := range map[string]string{
+		"Hello, World!":     "hello-world",
+		"  Crème Brûlée  ":  "creme-brulee",
+		"Straße 42 -- Nord": "straße-42-nord",
+		"日本語 ブログ":           "日本語-ブログ",
+		"!!!":               "",
+	} {
+		if slug := slugify(s); slug != expected {
+			t.Errorf("%q: expected %q, got %q", s, expected, slug)
+		}
+	}
+}
+
+func TestSlugifyHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/posts", func(in json.RawMessage) json.RawMessage { return in }, NewSlugifyHook("name", "title:titleSlug"))
+
+	out, err := api.Call(context.Background(), "POST", "/posts", []byte(`{"name":"My Post","title":"Intro: Part 1"}`))
+	expected := `{"name":"My Post","slug":"my-post","title":"Intro: Part 1","titleSlug":"intro-part-1"}`
+	if err != nil || string(out.(json.RawMessage)) != expected {
+		t.Errorf("Unexpected result %s, %v", out, err)
+	}
+	out, err = api.Call(context.Background(), "POST", "/posts", []byte(`{"name":"My Post","slug":"custom"}`))
+	if err != nil || string(out.(json.RawMessage)) != `{"name":"My Post","slug":"custom"}` {
+		t.Errorf("Expected client slug to be kept, got %s, %v", out, err)
+	}
+	if _, err := api.Call(context.Background(), "POST", "/posts", []byte(`{"name":1}`)); ErrorStatusCode(err) != http.StatusBadRequest {
+		t.Errorf("Expected 400 for non-string field, got %v", err)
+	}
+}
+
+func TestSecretMaskHook(t *testing.T) {
+	type user struct {
+		Name   string `json:"name"`
+		APIKey string `json:"apiKey"`
+	}
+	type account struct {
+		User    user              `json:"user"`
+		Members []user            `json:"members"`
+		Tokens  map[string]string `json:"tokens"`
+	}
+	api := API{}
+	api.AddEndpoint("GET/account", func() account {
+		return account{
+			User:    user{"jo", "key1"},
+			Members: []user{{"al", "key2"}},
+			Tokens:  map[string]string{"refresh": "r1"},
+		}
+	}, After(NewSecretMaskHook([]string{"user.apiKey", "members.apiKey", "tokens.refresh", "tokens.missing"})))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/account", nil))
+	expected := `{"members":[{"apiKey":"[MASKED]","name":"al"}],"tokens":{"refresh":"[MASKED]"},"user":{"apiKey":"[MASKED]","name":"jo"}}`
+	if strings.TrimSpace(rec.Body.String()) != expected {
+		t.Errorf("Unexpected body %s", rec.Body)
+	}
+}
+
+func TestConditionalResponseHook(t *testing.T) {
+	isAdmin := func(ctx context.Context, out interface{}) bool {
+		return ContextJWTClaims(ctx)["role"] == "admin"
+	}
+	addInternal := func(out interface{}) interface{} {
+		return map[string]interface{}{"name": out, "internalId": 7}
+	}
+	api := API{}
+	api.AddEndpoint("GET/item", func() string { return "widget" }, After(NewConditionalResponseHook(isAdmin, addInternal)))
+
+	for role, expected := range map[string]interface{}{
+		"admin": map[string]interface{}{"name": "widget", "internalId": 7},
+		"user":  "widget",
+	} {
+		ctx := SetContextJWTClaims(context.Background(), JWTClaims{"role": role})
+		out, err := api.Call(ctx, "GET", "/item", nil)
+		if err != nil || fmt.Sprint(out) != fmt.Sprint(expected) {
+			t.Errorf("%s: expected %v, got %v, %v", role, expected, out, err)
+		}
+	}
+}
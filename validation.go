@@ -0,0 +1,288 @@
+package dispatch
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// A queryRule checks a single query parameter value, returning a description
+// of the problem if it is invalid.
+type queryRule func(value string) string
+
+// NewValidationHook returns a middleware hook that validates the request's
+// query parameters. The rules map each parameter name to a comma-separated
+// list of checks:
+//
+//	required  the parameter must be present and not empty
+//	int       the value must be an integer
+//	min=N     the value must be a number no less than N
+//	max=N     the value must be a number no greater than N
+//
+// Checks other than required are skipped for parameters that are missing, and
+// only the first failed check is reported for each parameter. Requests with
+// invalid parameters fail with status 400, listing each problem. An error is
+// returned if any of the rules cannot be parsed.
+func NewValidationHook(rules map[string]string) (MiddlewareHook, error) {
+	params := make([]string, 0, len(rules))
+	required := make(map[string]bool)
+	checks := make(map[string][]queryRule)
+	for param, rule := range rules {
+		params = append(params, param)
+		for _, check := range strings.Split(rule, ",") {
+			check = strings.TrimSpace(check)
+			if check == "required" {
+				required[param] = true
+				continue
+			}
+			queryRule, err := parseQueryRule(check)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validation rule for %s: %v", param, err)
+			}
+			checks[param] = append(checks[param], queryRule)
+		}
+	}
+	sort.Strings(params)
+
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		var problems []string
+		for _, param := range params {
+			value := input.QueryParams.Get(param)
+			if value == "" {
+				if required[param] {
+					problems = append(problems, param+": is required")
+				}
+				continue
+			}
+			for _, check := range checks[param] {
+				if problem := check(value); problem != "" {
+					problems = append(problems, param+": "+problem)
+					break
+				}
+			}
+		}
+		if len(problems) > 0 {
+			return nil, NewAPIError(http.StatusBadRequest, "validation failed: "+strings.Join(problems, "; "))
+		}
+		return input, nil
+	}, nil
+}
+
+// MustNewValidationHook is like NewValidationHook, but panics if any of the
+// rules cannot be parsed.
+func MustNewValidationHook(rules map[string]string) MiddlewareHook {
+	hook, err := NewValidationHook(rules)
+	if err != nil {
+		panic("dispatch: " + err.Error())
+	}
+	return hook
+}
+
+// parseQueryRule parses a single check other than required.
+func parseQueryRule(check string) (queryRule, error) {
+	if check == "int" {
+		return func(value string) string {
+			if _, err := strconv.Atoi(value); err != nil {
+				return "must be an integer"
+			}
+			return ""
+		}, nil
+	}
+	name, arg, ok := strings.Cut(check, "=")
+	if !ok || (name != "min" && name != "max") {
+		return nil, fmt.Errorf("unknown check %q", check)
+	}
+	limit, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad limit in %q", check)
+	}
+	return func(value string) string {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "must be a number"
+		}
+		if name == "min" && n < limit {
+			return "must be at least " + arg
+		}
+		if name == "max" && n > limit {
+			return "must be at most " + arg
+		}
+		return ""
+	}, nil
+}
+
+// NewJSONSchemaHook returns a middleware hook that validates the request body
+// against a JSON Schema before the handler unmarshals it. Requests with a body
+// that does not conform fail with status 400, listing each validation error. An
+// empty body is validated as null. The schema is compiled once, and an error is
+// returned if it cannot be compiled.
+func NewJSONSchemaHook(schema []byte) (MiddlewareHook, error) {
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %v", err)
+	}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		body := []byte(input.Input)
+		if len(body) == 0 {
+			body = []byte("null")
+		}
+		result, err := compiled.Validate(gojsonschema.NewBytesLoader(body))
+		if err != nil {
+			return nil, NewAPIError(http.StatusBadRequest, "validation failed: "+err.Error())
+		}
+		if !result.Valid() {
+			problems := make([]string, len(result.Errors()))
+			for i, resultErr := range result.Errors() {
+				problems[i] = resultErr.String()
+			}
+			return nil, NewAPIError(http.StatusBadRequest, "validation failed: "+strings.Join(problems, "; "))
+		}
+		return input, nil
+	}, nil
+}
+
+// MustNewJSONSchemaHook is like NewJSONSchemaHook, but panics if the schema
+// cannot be compiled.
+func MustNewJSONSchemaHook(schema []byte) MiddlewareHook {
+	hook, err := NewJSONSchemaHook(schema)
+	if err != nil {
+		panic("dispatch: " + err.Error())
+	}
+	return hook
+}
+
+// NewRequireHeaderHook returns a middleware hook that fails requests without
+// the headerName header with missingErr, or with status 400 if missingErr is
+// nil.
+func NewRequireHeaderHook(headerName string, missingErr *APIError) MiddlewareHook {
+	if missingErr == nil {
+		missingErr = NewAPIError(http.StatusBadRequest, "missing required header: "+headerName)
+	}
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if input.Headers.Get(headerName) == "" {
+			return nil, missingErr
+		}
+		return input, nil
+	}
+}
+
+// NewContentTypeHook returns a middleware hook that fails requests with a body
+// whose Content-Type is not the required media type, such as
+// application/json, with status 415. Parameters such as charset are ignored,
+// and requests without a body are allowed.
+func NewContentTypeHook(required string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if len(input.Input) == 0 {
+			return input, nil
+		}
+		mediaType, _, err := mime.ParseMediaType(input.Headers.Get("Content-Type"))
+		if err != nil || !strings.EqualFold(mediaType, required) {
+			return nil, NewAPIError(http.StatusUnsupportedMediaType, "unsupported media type")
+		}
+		return input, nil
+	}
+}
+
+// NewAcceptHook returns a middleware hook that negotiates the response content
+// type from the request's Accept header, choosing the media type in supported
+// with the highest quality value, or the first of those with equal quality.
+// Requests without an Accept header get the first supported type. The chosen
+// type is stored in the context, where handlers and hooks can read it with
+// ContextResponseContentType. The hook does not set the response's
+// Content-Type, since the proxies still encode ordinary outputs as JSON;
+// handlers that encode their output to match the chosen type must set the
+// header themselves, such as with a *Response. Requests that accept none of the
+// supported types fail with status 406.
+func NewAcceptHook(supported []string) MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		contentType := negotiateContentType(input.Headers.Get("Accept"), supported)
+		if contentType == "" {
+			return nil, NewAPIError(http.StatusNotAcceptable, "not acceptable")
+		}
+		input.Ctx = SetContextResponseContentType(input.Ctx, contentType)
+		return input, nil
+	}
+}
+
+// negotiateContentType returns the type in supported that is most preferred by
+// an Accept header, or an empty string if none is acceptable.
+func negotiateContentType(accept string, supported []string) string {
+	if strings.TrimSpace(accept) == "" {
+		if len(supported) == 0 {
+			return ""
+		}
+		return supported[0]
+	}
+	best, bestQ := "", 0.0
+	for _, contentType := range supported {
+		if q := acceptQuality(accept, contentType); q > bestQ {
+			best, bestQ = contentType, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality value that an Accept header gives
+// contentType, using the most specific media range that matches it.
+func acceptQuality(accept, contentType string) float64 {
+	typ, subtype, _ := strings.Cut(strings.ToLower(contentType), "/")
+	q, specificity := 0.0, -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		rangeType, rangeSubtype, _ := strings.Cut(mediaType, "/")
+		var s int
+		switch {
+		case rangeType == typ && rangeSubtype == subtype:
+			s = 2
+		case rangeType == typ && rangeSubtype == "*":
+			s = 1
+		case rangeType == "*" && rangeSubtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity = s
+		q = 1
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
+
+// NewBodyRequiredHook returns a middleware hook that fails requests with an
+// empty or null body with status 400, so that handlers taking a struct input do
+// not silently get its zero value when a client forgets to send the body.
+func NewBodyRequiredHook() MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if body := strings.TrimSpace(string(input.Input)); body == "" || body == "null" {
+			return nil, NewAPIError(http.StatusBadRequest, "request body is required")
+		}
+		return input, nil
+	}
+}
+
+// NewNoBodyHook returns a middleware hook that fails requests with a body with
+// status 400, for endpoints such as GET and DELETE that should never be sent
+// one.
+func NewNoBodyHook() MiddlewareHook {
+	return func(input *EndpointInput) (*EndpointInput, error) {
+		if len(input.Input) > 0 {
+			return nil, NewAPIError(http.StatusBadRequest, "request body not allowed for this method")
+		}
+		return input, nil
+	}
+}
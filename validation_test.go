@@ -0,0 +1,210 @@
+package dispatch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestValidationHook(t *testing.T) {
+	api := API{}
+	hook := MustNewValidationHook(map[string]string{
+		"page":      "int,min=1",
+		"page_size": "min=1,max=100",
+		"q":         "required",
+	})
+	api.AddEndpoint("GET/items", func() {}, hook)
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/items?q=x&page=2&page_size=10", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/items?page=0&page_size=500", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusBadRequest || !strings.HasPrefix(body, "validation failed: ") {
+		t.Fatalf("Expected validation error, got %d %s", rec.Code, body)
+	}
+	for _, expected := range []string{"page: must be at least 1", "page_size: must be at most 100", "q: is required"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in %s", expected, body)
+		}
+	}
+
+	res, _ := api.LambdaProxy("*")(&events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/items",
+		QueryStringParameters: map[string]string{"q": "x", "page": "one"},
+	})
+	if res.StatusCode != http.StatusBadRequest || !strings.Contains(res.Body, "page: must be an integer") {
+		t.Errorf("Unexpected response %d %s", res.StatusCode, res.Body)
+	}
+
+	if _, err := NewValidationHook(map[string]string{"page": "int,min=x"}); err == nil {
+		t.Error("Expected error for invalid rule")
+	}
+}
+
+func TestJSONSchemaHook(t *testing.T) {
+	schema := []byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"age": {"type": "integer", "minimum": 0}
+		},
+		"required": ["name"]
+	}`)
+	api := API{}
+	api.AddEndpoint("POST/users", func() {}, MustNewJSONSchemaHook(schema))
+
+	if _, err := api.Call(context.Background(), "POST", "/users", []byte(`{"name":"alice","age":30}`)); err != nil {
+		t.Error(err)
+	}
+	for _, body := range []string{`{"age":-1}`, `{"name":1}`, `[]`, `not json`, ``} {
+		_, err := api.Call(context.Background(), "POST", "/users", []byte(body))
+		if ErrorStatusCode(err) != http.StatusBadRequest || !strings.HasPrefix(err.Error(), "validation failed: ") {
+			t.Errorf("Body %q: expected validation error, got %v", body, err)
+		}
+	}
+	_, err := api.Call(context.Background(), "POST", "/users", []byte(`{"age":-1}`))
+	if !strings.Contains(err.Error(), "name") || !strings.Contains(err.Error(), "age") {
+		t.Errorf("Expected errors for each field, got %v", err)
+	}
+
+	if _, err := NewJSONSchemaHook([]byte(`{"type":"nonsense"}`)); err == nil {
+		t.Error("Expected error for invalid schema")
+	}
+}
+
+func TestRequireHeaderHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("GET/default", func() {}, NewRequireHeaderHook("X-Tenant-ID", nil))
+	api.AddEndpoint("GET/custom", func() {}, NewRequireHeaderHook("X-Tenant-ID", NewAPIError(401, "no tenant")))
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/default", nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "missing required header: X-Tenant-ID") {
+		t.Errorf("Unexpected response %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("GET", "/custom", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected custom error, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/default", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	api.HTTPProxy(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestContentTypeHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/items", func() {}, NewContentTypeHook("application/json"))
+
+	for contentType, expected := range map[string]int{
+		"application/json":                  http.StatusOK,
+		"application/json; charset=utf-8":   http.StatusOK,
+		"application/x-www-form-urlencoded": http.StatusUnsupportedMediaType,
+		"":                                  http.StatusUnsupportedMediaType,
+	} {
+		req := httptest.NewRequest("POST", "/items", strings.NewReader(`{}`))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, req)
+		if rec.Code != expected {
+			t.Errorf("Content-Type %q: expected %d, got %d", contentType, expected, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("POST", "/items", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected request without a body to be allowed, got %d", rec.Code)
+	}
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	supported := []string{"application/json", "text/csv"}
+	for accept, expected := range map[string]string{
+		"":                                      "application/json",
+		"*/*":                                   "application/json",
+		"text/csv":                              "text/csv",
+		"text/*, application/json;q=0.5":        "text/csv",
+		"application/json, text/csv":            "application/json",
+		"text/*;q=0.2, text/csv;q=0, */*;q=0.1": "application/json",
+		"image/png":                             "",
+	} {
+		if got := negotiateContentType(accept, supported); got != expected {
+			t.Errorf("Accept %q: expected %q, got %q", accept, expected, got)
+		}
+	}
+}
+
+func TestAcceptHook(t *testing.T) {
+	api := API{}
+	handler := func(ctx context.Context) string { return ContextResponseContentType(ctx) }
+	api.AddEndpoint("GET/report", handler, NewAcceptHook([]string{"application/json", "text/csv"}))
+
+	req := httptest.NewRequest("GET", "/report", nil)
+	req.Header.Set("Accept", "text/csv")
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != `"text/csv"` {
+		t.Errorf("Unexpected response %v %s", rec.Header(), rec.Body)
+	}
+
+	req.Header.Set("Accept", "image/png")
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, req)
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected 406, got %d", rec.Code)
+	}
+}
+
+func TestBodyRequiredHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("POST/items", func() {}, NewBodyRequiredHook())
+
+	for body, expected := range map[string]int{
+		`{"name":"a"}`: http.StatusOK,
+		`{}`:           http.StatusOK,
+		`null`:         http.StatusBadRequest,
+		``:             http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		api.HTTPProxy(rec, httptest.NewRequest("POST", "/items", strings.NewReader(body)))
+		if rec.Code != expected {
+			t.Errorf("Body %q: expected %d, got %d %s", body, expected, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestNoBodyHook(t *testing.T) {
+	api := API{}
+	api.AddEndpoint("DELETE/items/{id}", func() {}, NewNoBodyHook())
+
+	rec := httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("DELETE", "/items/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	api.HTTPProxy(rec, httptest.NewRequest("DELETE", "/items/1", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "request body not allowed") {
+		t.Errorf("Unexpected response %d %s", rec.Code, rec.Body)
+	}
+}